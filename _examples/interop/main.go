@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/muka/peerjs-go"
+	"github.com/muka/peerjs-go/enums"
 )
 
 func fail(err error) {
@@ -38,7 +39,7 @@ func main() {
 	})
 
 	connOpts := peer.NewConnectionOptions()
-	connOpts.Serialization = peer.SerializationTypeNone
+	connOpts.Serialization = enums.SerializationTypeNone
 	conn1, err := peer1.Connect("peerjs", connOpts)
 	fail(err)
 	conn1.On("open", func(data interface{}) {
@@ -1,27 +1,43 @@
 package peer
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"time"
 
-	"github.com/sirupsen/logrus"
+	"github.com/muka/peerjs-go/util"
 )
 
+// ErrPeerDiscoveryDisabled is returned by ListAllPeers when the server does
+// not allow to list the peers
+var ErrPeerDiscoveryDisabled = errors.New("peer discovery is disabled, enable the AllowDiscovery server option")
+
+// APIError is returned when the server replies with an error status
+type APIError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e APIError) Error() string {
+	return fmt.Sprintf("Request %s failed: %s", e.URL, e.Status)
+}
+
 // NewAPI initiate a new API client
 func NewAPI(opts Options) API {
 	return API{
 		opts: opts,
-		log:  createLogger("api", opts.Debug),
+		log:  createLogger("api", opts.Debug, opts.Logger),
 	}
 }
 
 // API wrap calls to API server
 type API struct {
 	opts Options
-	log  *logrus.Entry
+	log  Logger
 }
 
 func (a *API) buildURL(method string) string {
@@ -30,10 +46,7 @@ func (a *API) buildURL(method string) string {
 		proto = "https"
 	}
 
-	path := a.opts.Path
-	if path == "/" {
-		path = ""
-	}
+	path := util.NormalizePath(a.opts.Path)
 
 	return fmt.Sprintf(
 		"%s://%s:%d%s/%s/%s?ts=%d%d",
@@ -48,16 +61,27 @@ func (a *API) buildURL(method string) string {
 	)
 }
 
+// httpClient return the client used for API calls, applying TLSConfig to
+// secure connections
+func (a *API) httpClient() *http.Client {
+	if !a.opts.Secure || a.opts.TLSConfig == nil {
+		return http.DefaultClient
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = a.opts.TLSConfig
+	return &http.Client{Transport: transport}
+}
+
 func (a *API) req(method string) ([]byte, error) {
 	uri := a.buildURL(method)
-	resp, err := http.Get(uri)
+	resp, err := a.httpClient().Get(uri)
 	if err != nil {
 		return []byte{}, err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		return []byte{}, fmt.Errorf("Request %s failed: %s", uri, resp.Status)
+		return []byte{}, APIError{URL: uri, StatusCode: resp.StatusCode, Status: resp.Status}
 	}
-	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return []byte{}, err
@@ -73,5 +97,9 @@ func (a *API) RetrieveID() ([]byte, error) {
 
 // ListAllPeers return the list of available peers
 func (a *API) ListAllPeers() ([]byte, error) {
-	return a.req("peers")
+	raw, err := a.req("peers")
+	if apiErr, ok := err.(APIError); ok && apiErr.StatusCode == http.StatusUnauthorized {
+		return raw, ErrPeerDiscoveryDisabled
+	}
+	return raw, err
 }
@@ -68,6 +68,66 @@ func main() {
 	if viper.IsSet("CleanupOutMsgs") {
 		opts.CleanupOutMsgs = viper.GetInt("CleanupOutMsgs")
 	}
+	if viper.IsSet("AllowedOrigins") {
+		opts.AllowedOrigins = viper.GetStringSlice("AllowedOrigins")
+	}
+	if viper.IsSet("ReadBufferSize") {
+		opts.ReadBufferSize = viper.GetInt("ReadBufferSize")
+	}
+	if viper.IsSet("WriteBufferSize") {
+		opts.WriteBufferSize = viper.GetInt("WriteBufferSize")
+	}
+	if viper.IsSet("MessageRateLimit") {
+		opts.MessageRateLimit = viper.GetInt("MessageRateLimit")
+	}
+	if viper.IsSet("MessageBurst") {
+		opts.MessageBurst = viper.GetInt("MessageBurst")
+	}
+	if viper.IsSet("MaxMessageSize") {
+		opts.MaxMessageSize = viper.GetInt64("MaxMessageSize")
+	}
+	if viper.IsSet("EnableMetrics") {
+		opts.EnableMetrics = viper.GetBool("EnableMetrics")
+	}
+	if viper.IsSet("TurnSecret") {
+		opts.TurnSecret = viper.GetString("TurnSecret")
+	}
+	if viper.IsSet("TurnURLs") {
+		opts.TurnURLs = viper.GetStringSlice("TurnURLs")
+	}
+	if viper.IsSet("TurnTTL") {
+		opts.TurnTTL = viper.GetInt64("TurnTTL")
+	}
+	if viper.IsSet("AllowServerGeneratedIDs") {
+		opts.AllowServerGeneratedIDs = viper.GetBool("AllowServerGeneratedIDs")
+	}
+	if viper.IsSet("IDPattern") {
+		opts.IDPattern = viper.GetString("IDPattern")
+	}
+	if viper.IsSet("CORSOrigins") {
+		opts.CORSOrigins = viper.GetStringSlice("CORSOrigins")
+	}
+	if viper.IsSet("MaxConnectionsPerIP") {
+		opts.MaxConnectionsPerIP = viper.GetInt("MaxConnectionsPerIP")
+	}
+	if viper.IsSet("TrustProxy") {
+		opts.TrustProxy = viper.GetBool("TrustProxy")
+	}
+	if viper.IsSet("TrustedProxies") {
+		opts.TrustedProxies = viper.GetStringSlice("TrustedProxies")
+	}
+	if viper.IsSet("DisableLeaveOnDisconnect") {
+		opts.DisableLeaveOnDisconnect = viper.GetBool("DisableLeaveOnDisconnect")
+	}
+	if viper.IsSet("Subprotocols") {
+		opts.Subprotocols = viper.GetStringSlice("Subprotocols")
+	}
+	if viper.IsSet("DiscoveryLimit") {
+		opts.DiscoveryLimit = viper.GetInt("DiscoveryLimit")
+	}
+	if viper.IsSet("WriteTimeout") {
+		opts.WriteTimeout = viper.GetInt64("WriteTimeout")
+	}
 
 	s := server.New(opts)
 	defer s.Stop()
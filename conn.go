@@ -4,7 +4,6 @@ import (
 	"github.com/muka/peerjs-go/emitter"
 	"github.com/muka/peerjs-go/models"
 	"github.com/pion/webrtc/v3"
-	"github.com/sirupsen/logrus"
 )
 
 // Connection shared interface
@@ -27,12 +26,20 @@ func newBaseConnection(connType string, peer *Peer, opts ConnectionOptions) Base
 		Emitter:    emitter.NewEmitter(),
 		Type:       connType,
 		Provider:   peer,
-		log:        createLogger(connType, opts.Debug),
+		log:        createLogger(connType, opts.Debug, peerLogger(peer)),
 		opts:       opts,
 		negotiator: nil,
 	}
 }
 
+// peerLogger return the logger set in the peer options, if any
+func peerLogger(peer *Peer) Logger {
+	if peer == nil {
+		return nil
+	}
+	return peer.opts.Logger
+}
+
 // BaseConnection shared base connection
 type BaseConnection struct {
 	emitter.Emitter
@@ -61,7 +68,7 @@ type BaseConnection struct {
 	// BufferSize The number of messages queued to be sent once the browser buffer is no longer full.
 	BufferSize int
 	opts       ConnectionOptions
-	log        *logrus.Entry
+	log        Logger
 	negotiator *Negotiator
 }
 
@@ -2,7 +2,12 @@ package peer
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/muka/peerjs-go/enums"
 	"github.com/muka/peerjs-go/models"
@@ -15,14 +20,32 @@ const (
 	DataChannelIDPrefix = "dc_"
 	//MaxBufferedAmount max amount to buffer
 	MaxBufferedAmount = 8 * 1024 * 1024
+	//MaxPendingChunkedMessages max number of chunked messages being reassembled
+	MaxPendingChunkedMessages = 100
+	//MaxChunksPerMessage max number of chunks of a message
+	MaxChunksPerMessage = 65536
+	//MaxPendingChunkedBytes max size of the chunked messages being reassembled
+	MaxPendingChunkedBytes = 64 * 1024 * 1024
+	//ChunkedMessageTimeout time after which a chunked message not receiving
+	//chunks is dropped
+	ChunkedMessageTimeout = time.Second * 30
 )
 
+// bufferedAmountPollInterval how often a blocked Send checks the buffered amount
+const bufferedAmountPollInterval = time.Millisecond * 10
+
+// ErrBufferFull is returned by Send when sending the message would exceed the
+// MaxBufferedAmount option
+var ErrBufferFull = errors.New("data channel buffer is full")
+
 // NewDataConnection create new DataConnection
 func NewDataConnection(peerID string, peer *Peer, opts ConnectionOptions) (*DataConnection, error) {
 
 	d := &DataConnection{
 		BaseConnection: newBaseConnection(enums.ConnectionTypeData, peer, opts),
 		buffer:         bytes.NewBuffer([]byte{}),
+		chunkedData:    map[uint32]*chunkedData{},
+		drain:          make(chan struct{}, 1),
 		// encodingQueue:  NewEncodingQueue(),
 	}
 
@@ -55,18 +78,25 @@ func NewDataConnection(peerID string, peer *Peer, opts ConnectionOptions) (*Data
 }
 
 type chunkedData struct {
-	Data  []byte
-	Count int
-	Total int
+	Data    map[uint32][]byte
+	Size    int
+	Total   int
+	Updated time.Time
 }
 
 // DataConnection track a connection with a remote Peer
 type DataConnection struct {
 	BaseConnection
-	buffer     *bytes.Buffer
-	bufferSize int
-	buffering  bool
-	// chunkedData map[int]chunkedData
+	buffer      *bytes.Buffer
+	bufferSize  int
+	buffering   bool
+	chunkedData map[uint32]*chunkedData
+	chunkedSize int
+	chunkMutex  sync.Mutex
+	lastChunkID uint32
+	drain       chan struct{}
+	// dcMutex guards DataChannel
+	dcMutex sync.Mutex
 	// encodingQueue *EncodingQueue
 }
 
@@ -85,27 +115,39 @@ type DataConnection struct {
 
 // Initialize called by the Negotiator when the DataChannel is ready
 func (d *DataConnection) Initialize(dc *webrtc.DataChannel) {
+	d.dcMutex.Lock()
 	d.DataChannel = dc
-	d.configureDataChannel()
+	d.dcMutex.Unlock()
+	d.configureDataChannel(dc)
+}
+
+// dataChannel return the DataChannel, nil once closed
+func (d *DataConnection) dataChannel() *webrtc.DataChannel {
+	d.dcMutex.Lock()
+	defer d.dcMutex.Unlock()
+	return d.DataChannel
 }
 
-func (d *DataConnection) configureDataChannel() {
+func (d *DataConnection) configureDataChannel(dc *webrtc.DataChannel) {
 	// TODO
 	// d.DataChannel.binaryType = "arraybuffer";
 
-	d.DataChannel.OnOpen(func() {
+	dc.OnOpen(func() {
 		//TODO
 		d.log.Debugf(`DC#%s dc connection success`, d.GetID())
 		d.Open = true
 		d.Emit(enums.ConnectionEventTypeOpen, nil)
 	})
 
-	d.DataChannel.OnMessage(func(msg webrtc.DataChannelMessage) {
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
 		d.log.Debugf(`DC#%s dc onmessage: %v`, d.GetID(), msg.Data)
 		d.handleDataMessage(msg)
 	})
 
-	d.DataChannel.OnClose(func() {
+	dc.SetBufferedAmountLowThreshold(d.opts.BufferedAmountLowThreshold)
+	dc.OnBufferedAmountLow(d.onBufferedAmountLow)
+
+	dc.OnClose(func() {
 		d.log.Debugf(`DC#%s dc closed for %s`, d.GetID(), d.peerID)
 		d.Close()
 	})
@@ -115,63 +157,152 @@ func (d *DataConnection) configureDataChannel() {
 // Handles a DataChannel message.
 func (d *DataConnection) handleDataMessage(msg webrtc.DataChannelMessage) {
 
-	// isBinarySerialization := d.Serialization == SerializationTypeBinary ||
-	// 	d.Serialization == SerializationTypeBinaryUTF8
-
-	if msg.IsString {
-		d.Emit(enums.ConnectionEventTypeData, string(msg.Data))
-	} else {
+	switch d.Serialization {
+	case enums.SerializationTypeBinary, enums.SerializationTypeBinaryUTF8:
+		d.handleBinaryMessage(msg.Data)
+	case enums.SerializationTypeJSON:
+		var data interface{}
+		err := json.Unmarshal(msg.Data, &data)
+		if err != nil {
+			d.log.Warnf(`DC#%s Failed to decode JSON message: %s`, d.GetID(), err)
+			d.Emit(enums.ConnectionEventTypeError, err)
+			return
+		}
+		d.Emit(enums.ConnectionEventTypeData, data)
+	default:
+		if msg.IsString {
+			d.Emit(enums.ConnectionEventTypeData, string(msg.Data))
+			return
+		}
+		// Check if we've chunked--if so, piece things back together.
+		if d.opts.ChunkSize > 0 {
+			if frame, ok := util.ParseChunkFrame(msg.Data); ok {
+				data, ok := d.handleChunk(frame)
+				if ok {
+					d.Emit(enums.ConnectionEventTypeData, data)
+				}
+				return
+			}
+		}
 		d.Emit(enums.ConnectionEventTypeData, msg.Data)
 	}
+}
 
-	// if (isBinarySerialization) {
-	// 	if (datatype == Blob) {
-	//     // Datatype should never be blob
-	//     util.blobToArrayBuffer(data as Blob, (ab) => {
-	//       const unpackedData = util.unpack(ab);
-	//       d.emit(ConnectionEventType.Data, unpackedData);
-	//     });
-	//     return;
-	//   } else if (datatype === ArrayBuffer) {
-	//     deserializedData = util.unpack(data as ArrayBuffer);
-	//   } else if (datatype === String) {
-	//     // String fallback for binary data for browsers that don't support binary yet
-	//     const ab = util.binaryStringToArrayBuffer(data as string);
-	//     deserializedData = util.unpack(ab);
-	//   }
-	// } else if (d.serialization === SerializationType.JSON) {
-	//   deserializedData = d.parse(data as string);
-	// }
-	// // Check if we've chunked--if so, piece things back together.
-	// // We're guaranteed that this isn't 0.
-	// if deserializedData.__peerData {
-	// 	d.handleChunk(deserializedData)
-	// 	return
-	// }
-	// d.Emit(ConnectionEventTypeData, deserializedData)
+// handleBinaryMessage unpack a binarypack message, assembling chunked messages
+func (d *DataConnection) handleBinaryMessage(raw []byte) {
+	data, err := util.Unpack(raw)
+	if err != nil {
+		d.log.Warnf(`DC#%s Failed to unpack message: %s`, d.GetID(), err)
+		d.Emit(enums.ConnectionEventTypeError, err)
+		return
+	}
+
+	// Check if we've chunked--if so, piece things back together.
+	if frame, ok := parsePeerData(data); ok {
+		blob, ok := d.handleChunk(frame)
+		if ok {
+			d.handleBinaryMessage(blob)
+		}
+		return
+	}
+
+	d.Emit(enums.ConnectionEventTypeData, data)
 }
 
-// func (d *DataConnection) handleChunk(raw []byte) {
-// 	// const id = data.__peerData;
-// 	// const chunkInfo = d._chunkedData[id] || {
-// 	//   data: [],
-// 	//   count: 0,
-// 	//   total: data.total
-// 	// };
-
-// 	// chunkInfo.data[data.n] = data.data;
-// 	// chunkInfo.count++;
-// 	// d._chunkedData[id] = chunkInfo;
-
-// 	// if (chunkInfo.total === chunkInfo.count) {
-// 	//   // Clean up before making the recursive call to `_handleDataMessage`.
-// 	//   delete d._chunkedData[id];
-
-// 	//   // We've received all the chunks--time to construct the complete data.
-// 	//   const data = new Blob(chunkInfo.data);
-// 	//   d._handleDataMessage({ data });
-// 	// }
-// }
+// parsePeerData decode a chunk sent by PeerJS as {__peerData, n, total, data}
+func parsePeerData(data interface{}) (util.ChunkFrame, bool) {
+	chunk, ok := data.(map[string]interface{})
+	if !ok {
+		return util.ChunkFrame{}, false
+	}
+	id, ok1 := chunk["__peerData"].(int64)
+	n, ok2 := chunk["n"].(int64)
+	total, ok3 := chunk["total"].(int64)
+	raw, ok4 := chunk["data"].([]byte)
+	if !ok1 || !ok2 || !ok3 || !ok4 || n < 0 || n >= total || total > MaxChunksPerMessage {
+		return util.ChunkFrame{}, false
+	}
+	return util.ChunkFrame{
+		ID:    uint32(id),
+		N:     uint32(n),
+		Total: uint32(total),
+		Data:  raw,
+	}, true
+}
+
+// handleChunk store a chunk, returning the message once all the chunks are
+// received. Chunks may arrive out of order on unordered channels
+func (d *DataConnection) handleChunk(frame util.ChunkFrame) ([]byte, bool) {
+	if frame.Total > MaxChunksPerMessage || frame.Length > MaxPendingChunkedBytes {
+		d.log.Warnf(`DC#%s Chunked message %d is too large: %d chunks, %d bytes`, d.GetID(), frame.ID, frame.Total, frame.Length)
+		return nil, false
+	}
+
+	d.chunkMutex.Lock()
+	defer d.chunkMutex.Unlock()
+
+	d.expireChunks()
+
+	chunkInfo, ok := d.chunkedData[frame.ID]
+	if !ok {
+		if len(d.chunkedData) >= MaxPendingChunkedMessages {
+			d.log.Warnf(`DC#%s Too many chunked messages pending, dropping chunk of message %d`, d.GetID(), frame.ID)
+			return nil, false
+		}
+		chunkInfo = &chunkedData{
+			Data:  map[uint32][]byte{},
+			Total: int(frame.Total),
+		}
+		d.chunkedData[frame.ID] = chunkInfo
+	}
+	if _, ok := chunkInfo.Data[frame.N]; ok || int(frame.Total) != chunkInfo.Total {
+		d.log.Warnf(`DC#%s Invalid chunk %d of message %d`, d.GetID(), frame.N, frame.ID)
+		return nil, false
+	}
+	if d.chunkedSize+len(frame.Data) > MaxPendingChunkedBytes {
+		d.log.Warnf(`DC#%s Chunked messages pending exceed %d bytes, dropping message %d`, d.GetID(), MaxPendingChunkedBytes, frame.ID)
+		d.dropChunks(frame.ID)
+		return nil, false
+	}
+	chunkInfo.Data[frame.N] = append([]byte{}, frame.Data...)
+	chunkInfo.Size += len(frame.Data)
+	chunkInfo.Updated = time.Now()
+	d.chunkedSize += len(frame.Data)
+	if len(chunkInfo.Data) < chunkInfo.Total {
+		return nil, false
+	}
+	d.dropChunks(frame.ID)
+
+	// We've received all the chunks--time to construct the complete data.
+	data := make([]byte, 0, chunkInfo.Size)
+	for n := 0; n < chunkInfo.Total; n++ {
+		data = append(data, chunkInfo.Data[uint32(n)]...)
+	}
+	if frame.Length > 0 && uint32(len(data)) != frame.Length {
+		d.log.Warnf(`DC#%s Chunked message %d length mismatch, expected %d got %d`, d.GetID(), frame.ID, frame.Length, len(data))
+		return nil, false
+	}
+	return data, true
+}
+
+// dropChunks remove a chunked message, must be called holding chunkMutex
+func (d *DataConnection) dropChunks(id uint32) {
+	if chunkInfo, ok := d.chunkedData[id]; ok {
+		d.chunkedSize -= chunkInfo.Size
+		delete(d.chunkedData, id)
+	}
+}
+
+// expireChunks drop the chunked messages not receiving chunks within
+// ChunkedMessageTimeout, must be called holding chunkMutex
+func (d *DataConnection) expireChunks() {
+	for id, chunkInfo := range d.chunkedData {
+		if time.Since(chunkInfo.Updated) > ChunkedMessageTimeout {
+			d.log.Debugf(`DC#%s Chunked message %d expired`, d.GetID(), id)
+			d.dropChunks(id)
+		}
+	}
+}
 
 /**
  * Exposed functionality for users.
@@ -182,7 +313,10 @@ func (d *DataConnection) Close() error {
 
 	d.buffer = nil
 	d.bufferSize = 0
-	// d.chunkedData = map[int]chunkedData{}
+	d.chunkMutex.Lock()
+	d.chunkedData = map[uint32]*chunkedData{}
+	d.chunkedSize = 0
+	d.chunkMutex.Unlock()
 
 	if d.negotiator != nil {
 		d.negotiator.Cleanup()
@@ -194,11 +328,15 @@ func (d *DataConnection) Close() error {
 		d.Provider = nil
 	}
 
-	if d.DataChannel != nil {
-		d.DataChannel.OnOpen(func() {})
-		d.DataChannel.OnMessage(func(msg webrtc.DataChannelMessage) {})
-		d.DataChannel.OnClose(func() {})
-		d.DataChannel = nil
+	d.dcMutex.Lock()
+	dc := d.DataChannel
+	d.DataChannel = nil
+	d.dcMutex.Unlock()
+	if dc != nil {
+		dc.OnOpen(func() {})
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {})
+		dc.OnClose(func() {})
+		dc.OnBufferedAmountLow(func() {})
 	}
 
 	// if d.encodingQueue != nil {
@@ -216,8 +354,15 @@ func (d *DataConnection) Close() error {
 	return nil
 }
 
-// Send allows user to send data.
-func (d *DataConnection) Send(data []byte, chunked bool) error {
+// Send allows user to send data, encoded according to the connection
+// Serialization:
+// - raw and none send []byte as binary and string as text messages
+// - json sends the JSON encoding of data as a text message
+// - binary and binary-utf8 send the binarypack encoding of data, chunking it if
+// larger than util.ChunkedMTU
+// chunked is set when data is already a chunk. On an unreliable connection a
+// nil error does not guarantee the data is delivered to the remote peer.
+func (d *DataConnection) Send(data interface{}, chunked bool) error {
 	if !d.Open {
 		err := errors.New("Connection is not open. You should listen for the `open` event before sending messages")
 		d.Emit(
@@ -227,42 +372,104 @@ func (d *DataConnection) Send(data []byte, chunked bool) error {
 		return err
 	}
 
-	err := d.DataChannel.Send(data)
+	var err error
+	var raw []byte
+	text := false
+	switch d.Serialization {
+	case enums.SerializationTypeBinary, enums.SerializationTypeBinaryUTF8:
+		raw, err = util.Pack(data)
+		if err != nil {
+			return fmt.Errorf("Failed to pack message: %s", err)
+		}
+		if !chunked && len(raw) > util.ChunkedMTU {
+			return d.sendPeerDataChunks(raw)
+		}
+	case enums.SerializationTypeJSON:
+		raw, err = json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("Failed to encode JSON message: %s", err)
+		}
+		text = true
+	default:
+		switch value := data.(type) {
+		case []byte:
+			if !chunked && d.opts.ChunkSize > 0 && len(value) > d.opts.ChunkSize {
+				return d.sendChunks(value)
+			}
+			raw = value
+		case string:
+			raw = []byte(value)
+			text = true
+		default:
+			return fmt.Errorf("Cannot send %T with %s serialization, use []byte or string", data, d.Serialization)
+		}
+	}
+
+	err = d.waitBufferedAmount(len(raw))
+	if err != nil {
+		return err
+	}
+
+	dc := d.dataChannel()
+	if dc == nil {
+		return errors.New("Connection closed")
+	}
+	if text {
+		err = dc.SendText(string(raw))
+	} else {
+		err = dc.Send(raw)
+	}
 	if err != nil {
 		d.log.Warnf("Send failed: %s", err)
 		return err
 	}
 
 	return nil
+}
 
-	// if d.Serialization == SerializationTypeJSON {
-	// 	// JSON data must be marshalled before send!
-	// 	d.log.Debug("Send JSON")
-	// 	d.bufferedSend(raw)
-	// } else if d.Serialization == SerializationTypeBinary || d.Serialization == SerializationTypeBinaryUTF8 {
-
-	// 	panic(errors.New("binarypack encoding is not supported"))
-
-	// 	// NOTE we pack with MessagePack not with binarypack. Understant if this is good enough
-	// 	// blob, err := msgpack.Marshal(data)
-	// 	// if err != nil {
-	// 	// 	return fmt.Errorf("Failed to pack message: %s", err)
-	// 	// }
-
-	// 	// if !chunked && len(blob) > ChunkedMTU {
-	// 	// 	d.log.Debug("Chunk payload")
-	// 	// 	d.sendChunks(blob)
-	// 	// 	return nil
-	// 	// }
-
-	// 	// d.log.Debugf("Send encoded payload %v", raw)
-	// 	// d.bufferedSend(blob)
+// BufferedAmount return the number of bytes queued to be sent on the data channel
+func (d *DataConnection) BufferedAmount() uint64 {
+	dc := d.dataChannel()
+	if dc == nil {
+		return 0
+	}
+	return dc.BufferedAmount()
+}
 
-	// } else {
-	// 	d.log.Debug("Send raw payload")
-	// 	d.bufferedSend(raw)
-	// }
+// waitBufferedAmount check there is room for size bytes below the
+// MaxBufferedAmount option, blocking until the buffer drains if
+// BlockOnBufferFull is set. Messages larger than MaxBufferedAmount never fit
+// and fail right away
+func (d *DataConnection) waitBufferedAmount(size int) error {
+	limit := d.opts.MaxBufferedAmount
+	if limit == 0 {
+		return nil
+	}
+	if uint64(size) > limit {
+		return ErrBufferFull
+	}
+	for d.BufferedAmount()+uint64(size) > limit {
+		if !d.opts.BlockOnBufferFull {
+			return ErrBufferFull
+		}
+		select {
+		case <-d.drain:
+		case <-time.After(bufferedAmountPollInterval):
+		}
+		if !d.Open {
+			return errors.New("Connection closed while waiting for the buffer to drain")
+		}
+	}
+	return nil
+}
 
+// onBufferedAmountLow notify the blocked senders and the listeners
+func (d *DataConnection) onBufferedAmountLow() {
+	select {
+	case d.drain <- struct{}{}:
+	default:
+	}
+	d.Emit(enums.ConnectionEventTypeBufferedAmountLow, d.BufferedAmount())
 }
 
 // func (d *DataConnection) bufferedSend(msg []byte) {
@@ -317,15 +524,39 @@ func (d *DataConnection) Send(data []byte, chunked bool) error {
 // 	}
 // }
 
-// func (d *DataConnection) sendChunks(raw []byte) {
-// 	panic("sendChunks: binarypack not implemented, please use SerializationTypeRaw")
-// 	// // this method requires a [binarypack] encoding to work
-// 	// chunks := util.Chunk(raw)
-// 	// d.log.Debugf(`DC#%s Try to send %d chunks...`, d.GetID(), len(chunks))
-// 	// for _, chunk := range chunks {
-// 	// 	d.Send(chunk, true)
-// 	// }
-// }
+// sendPeerDataChunks send a binarypack blob in PeerJS chunks
+func (d *DataConnection) sendPeerDataChunks(blob []byte) error {
+	chunks := util.Chunk(blob)
+	d.log.Debugf(`DC#%s Try to send %d chunks...`, d.GetID(), len(chunks))
+	for _, chunk := range chunks {
+		err := d.Send(map[string]interface{}{
+			"__peerData": chunk.PeerData,
+			"n":          chunk.N,
+			"total":      chunk.Total,
+			"data":       chunk.Data,
+		}, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendChunks split raw in chunks of at most ChunkSize bytes and send them
+func (d *DataConnection) sendChunks(raw []byte) error {
+	frames, err := util.ChunkFrames(atomic.AddUint32(&d.lastChunkID, 1), raw, d.opts.ChunkSize)
+	if err != nil {
+		return err
+	}
+	d.log.Debugf(`DC#%s Try to send %d chunks...`, d.GetID(), len(frames))
+	for _, frame := range frames {
+		err := d.Send(frame, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
 // HandleMessage handles incoming messages
 func (d *DataConnection) HandleMessage(message *models.Message) error {
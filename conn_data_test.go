@@ -0,0 +1,221 @@
+package peer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/muka/peerjs-go/enums"
+	"github.com/muka/peerjs-go/util"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDataConnection() *DataConnection {
+	return &DataConnection{
+		BaseConnection: newBaseConnection(enums.ConnectionTypeData, nil, *NewConnectionOptions()),
+		chunkedData:    map[uint32]*chunkedData{},
+		drain:          make(chan struct{}, 1),
+	}
+}
+
+func TestDataConnectionChunksOutOfOrder(t *testing.T) {
+	d := newTestDataConnection()
+	d.opts.ChunkSize = util.ChunkedMTU
+	received := make(chan interface{}, 2)
+	d.On(enums.ConnectionEventTypeData, func(data interface{}) {
+		received <- data
+	})
+
+	raw := bytes.Repeat([]byte("chunk"), 100)
+	frames, err := util.ChunkFrames(1, raw, util.ChunkHeaderSize+64)
+	assert.NoError(t, err)
+	assert.Greater(t, len(frames), 2)
+
+	// deliver in reverse order, with a plain message in between
+	for i := len(frames) - 1; i >= 0; i-- {
+		d.handleDataMessage(webrtc.DataChannelMessage{Data: frames[i]})
+		if i == 1 {
+			d.handleDataMessage(webrtc.DataChannelMessage{Data: []byte("plain")})
+		}
+	}
+
+	assert.Equal(t, []byte("plain"), <-received)
+	assert.Equal(t, raw, <-received)
+	assert.Empty(t, d.chunkedData)
+}
+
+func TestDataConnectionChunksDuplicated(t *testing.T) {
+	d := newTestDataConnection()
+	d.opts.ChunkSize = util.ChunkedMTU
+	received := make(chan interface{}, 2)
+	d.On(enums.ConnectionEventTypeData, func(data interface{}) {
+		received <- data
+	})
+
+	raw := bytes.Repeat([]byte("chunk"), 100)
+	frames, err := util.ChunkFrames(1, raw, util.ChunkHeaderSize+256)
+	assert.NoError(t, err)
+	assert.Len(t, frames, 2)
+
+	d.handleDataMessage(webrtc.DataChannelMessage{Data: frames[0]})
+	d.handleDataMessage(webrtc.DataChannelMessage{Data: frames[0]})
+	assert.Empty(t, received)
+	d.handleDataMessage(webrtc.DataChannelMessage{Data: frames[1]})
+	assert.Equal(t, raw, <-received)
+}
+
+func TestDataConnectionChunksAbandoned(t *testing.T) {
+	d := newTestDataConnection()
+	d.opts.ChunkSize = util.ChunkedMTU
+	received := make(chan interface{}, 1)
+	d.On(enums.ConnectionEventTypeData, func(data interface{}) {
+		received <- data
+	})
+
+	raw := bytes.Repeat([]byte("chunk"), 100)
+	// fill the pending messages sending only half of their chunks
+	for id := uint32(1); id <= MaxPendingChunkedMessages; id++ {
+		frames, err := util.ChunkFrames(id, raw, util.ChunkHeaderSize+64)
+		assert.NoError(t, err)
+		for _, frame := range frames[:len(frames)/2] {
+			d.handleDataMessage(webrtc.DataChannelMessage{Data: frame})
+		}
+	}
+	assert.Len(t, d.chunkedData, MaxPendingChunkedMessages)
+
+	frames, err := util.ChunkFrames(1000, raw, util.ChunkHeaderSize+64)
+	assert.NoError(t, err)
+	for _, frame := range frames {
+		d.handleDataMessage(webrtc.DataChannelMessage{Data: frame})
+	}
+	assert.Empty(t, received)
+
+	// the abandoned messages expire
+	d.chunkMutex.Lock()
+	for _, chunkInfo := range d.chunkedData {
+		chunkInfo.Updated = chunkInfo.Updated.Add(-ChunkedMessageTimeout * 2)
+	}
+	d.chunkMutex.Unlock()
+
+	for _, frame := range frames {
+		d.handleDataMessage(webrtc.DataChannelMessage{Data: frame})
+	}
+	assert.Equal(t, raw, <-received)
+	assert.Empty(t, d.chunkedData)
+	assert.Equal(t, 0, d.chunkedSize)
+
+	// pending messages are dropped on close
+	d.handleDataMessage(webrtc.DataChannelMessage{Data: frames[0]})
+	assert.Len(t, d.chunkedData, 1)
+	d.Close()
+	assert.Empty(t, d.chunkedData)
+	assert.Equal(t, 0, d.chunkedSize)
+}
+
+func TestDataConnectionChunksTooLarge(t *testing.T) {
+	d := newTestDataConnection()
+	d.opts.ChunkSize = util.ChunkedMTU
+
+	// declare a length above the limit
+	frame, err := util.PackChunkFrame(util.ChunkFrame{
+		ID:     1,
+		Total:  2,
+		Length: MaxPendingChunkedBytes + 1,
+		Data:   bytes.Repeat([]byte("chunk"), 10),
+	})
+	assert.NoError(t, err)
+	d.handleDataMessage(webrtc.DataChannelMessage{Data: frame})
+	assert.Empty(t, d.chunkedData)
+}
+
+func TestDataConnectionChunksDisabled(t *testing.T) {
+	d := newTestDataConnection()
+	received := make(chan interface{}, 1)
+	d.On(enums.ConnectionEventTypeData, func(data interface{}) {
+		received <- data
+	})
+
+	// a payload looking like a chunk frame is delivered as-is
+	frames, err := util.ChunkFrames(1, bytes.Repeat([]byte("chunk"), 100), util.ChunkHeaderSize+64)
+	assert.NoError(t, err)
+	d.handleDataMessage(webrtc.DataChannelMessage{Data: frames[0]})
+	assert.Equal(t, frames[0], <-received)
+	assert.Empty(t, d.chunkedData)
+}
+
+func TestDataConnectionChunkLikePayload(t *testing.T) {
+	d := newTestDataConnection()
+	d.opts.ChunkSize = util.ChunkedMTU
+	received := make(chan interface{}, 2)
+	d.On(enums.ConnectionEventTypeData, func(data interface{}) {
+		received <- data
+	})
+
+	// payloads sent unchunked are delivered as-is, whatever their content
+	header := make([]byte, 20)
+	copy(header, []byte{0x00, 'p', 'j', 'c'})
+	binary.BigEndian.PutUint32(header[12:], 2)
+	binary.BigEndian.PutUint32(header[16:], 100)
+	packed, err := util.Pack(map[string]interface{}{"n": 0, "total": 2})
+	assert.NoError(t, err)
+	for _, payload := range [][]byte{append(header, []byte("data")...), packed} {
+		d.handleDataMessage(webrtc.DataChannelMessage{Data: payload})
+		assert.Equal(t, payload, <-received)
+	}
+	assert.Empty(t, d.chunkedData)
+}
+
+func TestDataConnectionDecodeJSON(t *testing.T) {
+	d := newTestDataConnection()
+	d.Serialization = enums.SerializationTypeJSON
+	received := make(chan interface{}, 1)
+	d.On(enums.ConnectionEventTypeData, func(data interface{}) {
+		received <- data
+	})
+	d.handleDataMessage(webrtc.DataChannelMessage{IsString: true, Data: []byte(`{"hello":"world","n":1}`)})
+	assert.Equal(t, map[string]interface{}{"hello": "world", "n": float64(1)}, <-received)
+}
+
+func TestDataConnectionDecodeBinaryChunks(t *testing.T) {
+	d := newTestDataConnection()
+	d.Serialization = enums.SerializationTypeBinary
+	received := make(chan interface{}, 1)
+	d.On(enums.ConnectionEventTypeData, func(data interface{}) {
+		received <- data
+	})
+
+	payload := bytes.Repeat([]byte("binary"), util.ChunkedMTU)
+	blob, err := util.Pack(payload)
+	assert.NoError(t, err)
+	chunks := util.Chunk(blob)
+	assert.Greater(t, len(chunks), 1)
+
+	// chunks as packed by PeerJS, in reverse order
+	for i := len(chunks) - 1; i >= 0; i-- {
+		raw, err := util.Pack(map[string]interface{}{
+			"__peerData": chunks[i].PeerData,
+			"n":          chunks[i].N,
+			"total":      chunks[i].Total,
+			"data":       chunks[i].Data,
+		})
+		assert.NoError(t, err)
+		d.handleDataMessage(webrtc.DataChannelMessage{Data: raw})
+	}
+	assert.Equal(t, payload, <-received)
+}
+
+func TestDataConnectionMaxBufferedAmount(t *testing.T) {
+	d := newTestDataConnection()
+	assert.Equal(t, uint64(0), d.BufferedAmount())
+
+	assert.NoError(t, d.waitBufferedAmount(1024))
+
+	d.opts.MaxBufferedAmount = 512
+	assert.NoError(t, d.waitBufferedAmount(512))
+	assert.Equal(t, ErrBufferFull, d.waitBufferedAmount(1024))
+
+	// a blocked sender gives up once the connection is closed
+	d.opts.BlockOnBufferFull = true
+	assert.Error(t, d.waitBufferedAmount(1024))
+}
@@ -1,7 +1,9 @@
 package peer
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/muka/peerjs-go/enums"
 	"github.com/muka/peerjs-go/models"
@@ -12,6 +14,13 @@ import (
 // MediaChannelIDPrefix the media channel connection id prefix
 const MediaChannelIDPrefix = "mc_"
 
+// ErrAlreadyAnswered is returned when answering a MediaConnection twice
+var ErrAlreadyAnswered = errors.New("media connection already answered")
+
+// ErrDirectionNotSupported is returned calling or answering with a media
+// direction not supported
+var ErrDirectionNotSupported = errors.New("media direction not supported")
+
 // NewMediaConnection create new MediaConnection
 func NewMediaConnection(id string, peer *Peer, opts ConnectionOptions) (*MediaConnection, error) {
 
@@ -32,6 +41,7 @@ func NewMediaConnection(id string, peer *Peer, opts ConnectionOptions) (*MediaCo
 	var err error
 	if m.localStream != nil {
 		opts.Originator = true
+		m.answered = true
 		err = m.negotiator.StartConnection(opts)
 	}
 
@@ -44,6 +54,8 @@ type MediaConnection struct {
 	Open         bool
 	remoteStream *MediaStream
 	localStream  *MediaStream
+	answered     bool
+	sMutex       sync.Mutex
 }
 
 // GetLocalStream returns the local stream
@@ -53,13 +65,21 @@ func (m *MediaConnection) GetLocalStream() *MediaStream {
 
 // GetRemoteStream returns the remote stream
 func (m *MediaConnection) GetRemoteStream() *MediaStream {
+	m.sMutex.Lock()
+	defer m.sMutex.Unlock()
 	return m.remoteStream
 }
 
-// AddStream adds a stream to the MediaConnection
+// AddStream adds a remote track to the MediaConnection remote stream and
+// emits it with the stream event
 func (m *MediaConnection) AddStream(tr *webrtc.TrackRemote) {
 	m.log.Debugf("Receiving stream: %v", tr)
-	m.remoteStream = NewMediaStreamWithTrack([]MediaStreamTrack{tr})
+	m.sMutex.Lock()
+	if m.remoteStream == nil {
+		m.remoteStream = NewMediaStreamWithTrack([]MediaStreamTrack{})
+	}
+	m.remoteStream.AddTrack(tr)
+	m.sMutex.Unlock()
 	m.Emit(enums.ConnectionEventTypeStream, tr)
 }
 
@@ -82,15 +102,33 @@ func (m *MediaConnection) HandleMessage(message *models.Message) error {
 	return nil
 }
 
-// Answer open the media connection with the remote peer
-func (m *MediaConnection) Answer(tl webrtc.TrackLocal, options *AnswerOption) {
+// Answer open the media connection with the remote peer, sending tl or the
+// tracks of options.Stream. Answer without tracks to only receive the remote
+// media. Tracks of a kind not offered by the remote peer are not sent
+func (m *MediaConnection) Answer(tl webrtc.TrackLocal, options *AnswerOption) error {
 
-	if m.localStream != nil {
-		m.log.Warn("Local stream already exists on this MediaConnection. Are you answering a call twice?")
-		return
+	if m.answered {
+		m.log.Warnf("Local stream already exists on this MediaConnection. Are you answering a call twice?")
+		return ErrAlreadyAnswered
 	}
 
-	stream := NewMediaStreamWithTrack([]MediaStreamTrack{tl})
+	direction := webrtc.RTPTransceiverDirectionSendrecv
+	if options != nil && options.Direction != webrtc.RTPTransceiverDirection(webrtc.Unknown) {
+		direction = options.Direction
+	}
+	if direction != webrtc.RTPTransceiverDirectionSendrecv && direction != webrtc.RTPTransceiverDirectionRecvonly {
+		return ErrDirectionNotSupported
+	}
+	m.answered = true
+
+	stream := NewMediaStreamWithTrack([]MediaStreamTrack{})
+	if direction == webrtc.RTPTransceiverDirectionRecvonly {
+		// receive only, the local media is not sent
+	} else if options != nil && options.Stream != nil {
+		stream = options.Stream
+	} else if tl != nil {
+		stream.AddTrack(tl)
+	}
 	m.localStream = stream
 
 	if options != nil && options.SDPTransform != nil {
@@ -99,7 +137,10 @@ func (m *MediaConnection) Answer(tl webrtc.TrackLocal, options *AnswerOption) {
 
 	connOpts := m.GetOptions()
 	connOpts.Stream = stream
-	m.negotiator.StartConnection(connOpts)
+	err := m.negotiator.StartConnection(connOpts)
+	if err != nil {
+		return err
+	}
 	// Retrieve lost messages stored because PeerConnection not set up.
 	messages := m.GetProvider().GetMessages(m.GetID())
 
@@ -108,6 +149,7 @@ func (m *MediaConnection) Answer(tl webrtc.TrackLocal, options *AnswerOption) {
 	}
 
 	m.Open = true
+	return nil
 }
 
 // Close allows user to close connection
@@ -0,0 +1,20 @@
+package emitter
+
+// Subscriber registers event handlers, implemented by Emitter and the types
+// embedding it
+type Subscriber interface {
+	On(event string, handler EventHandler)
+}
+
+// OnTyped register a handler receiving the event data as T. Events carrying
+// data of another type are skipped. Note that handlers registered with
+// OnTyped can not be removed with Off
+func OnTyped[T any](e Subscriber, event string, handler func(T)) {
+	e.On(event, func(data interface{}) {
+		value, ok := data.(T)
+		if !ok {
+			return
+		}
+		handler(value)
+	})
+}
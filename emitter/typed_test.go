@@ -0,0 +1,33 @@
+package emitter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testEvent struct {
+	Value string
+}
+
+func TestOnTyped(t *testing.T) {
+	e := NewEmitter()
+	received := make(chan testEvent, 2)
+	OnTyped(&e, "event", func(ev testEvent) {
+		received <- ev
+	})
+
+	// mismatched payloads are skipped
+	e.Emit("event", "not an event")
+	e.Emit("event", nil)
+	e.Emit("event", testEvent{Value: "typed"})
+
+	select {
+	case ev := <-received:
+		assert.Equal(t, "typed", ev.Value)
+	case <-time.After(time.Second):
+		t.Fatal("event not received")
+	}
+	assert.Empty(t, received)
+}
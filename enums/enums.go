@@ -14,6 +14,8 @@ const (
 	ConnectionEventTypeError = "error"
 	//ConnectionEventTypeIceStateChanged enum for ICE state changes
 	ConnectionEventTypeIceStateChanged = "iceStateChanged"
+	//ConnectionEventTypeBufferedAmountLow enum for data channel buffer drained
+	ConnectionEventTypeBufferedAmountLow = "bufferedamountlow"
 	//ConnectionTypeData enum for data connection type
 	ConnectionTypeData = "data"
 	//ConnectionTypeMedia enum for media connection type
@@ -65,6 +67,8 @@ const (
 	SerializationTypeJSON = "json"
 	//SerializationTypeRaw Payload is sent as-is
 	SerializationTypeRaw = "raw"
+	//SerializationTypeNone Payload is sent as-is, as named by PeerJS
+	SerializationTypeNone = "none"
 
 	//SocketEventTypeMessage enum for socket message
 	SocketEventTypeMessage = "message"
@@ -74,6 +78,8 @@ const (
 	SocketEventTypeError = "error"
 	//SocketEventTypeClose enum for socket close
 	SocketEventTypeClose = "close"
+	//SocketEventTypeReconnecting enum for socket reconnection attempt
+	SocketEventTypeReconnecting = "reconnecting"
 
 	//ServerMessageTypeHeartbeat enum for server HEARTBEAT
 	ServerMessageTypeHeartbeat = "HEARTBEAT"
@@ -95,4 +101,23 @@ const (
 	ServerMessageTypeLeave = "LEAVE"
 	//ServerMessageTypeExpire enum for server EXPIRE
 	ServerMessageTypeExpire = "EXPIRE"
+
+	//ServerErrorTypeInvalidWSParameters enum for server error invalid-ws-parameters
+	ServerErrorTypeInvalidWSParameters = "invalid-ws-parameters"
+	//ServerErrorTypeInvalidKey enum for server error invalid-key
+	ServerErrorTypeInvalidKey = "invalid-key"
+	//ServerErrorTypeInvalidToken enum for server error invalid-token
+	ServerErrorTypeInvalidToken = "invalid-token"
+	//ServerErrorTypeInvalidID enum for server error invalid-id
+	ServerErrorTypeInvalidID = "invalid-id"
+	//ServerErrorTypeConnectionLimit enum for server error connection-limit
+	ServerErrorTypeConnectionLimit = "connection-limit"
+	//ServerErrorTypeIPConnectionLimit enum for server error ip-connection-limit
+	ServerErrorTypeIPConnectionLimit = "ip-connection-limit"
+	//ServerErrorTypeRateLimit enum for server error rate-limit
+	ServerErrorTypeRateLimit = "rate-limit"
+	//ServerErrorTypeServerError enum for server error server-error
+	ServerErrorTypeServerError = "server-error"
+	//ServerErrorTypeUnauthorized enum for server error unauthorized
+	ServerErrorTypeUnauthorized = "unauthorized"
 )
@@ -4,7 +4,23 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func createLogger(source string, debugLevel int8) *logrus.Entry {
+// Logger is the logging interface used by the peer, satisfied by *logrus.Entry
+// and zap's SugaredLogger
+type Logger interface {
+	Debug(args ...interface{})
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// createLogger return logger if set, otherwise a logrus logger with the
+// source field and the level set from debugLevel
+func createLogger(source string, debugLevel int8, logger Logger) Logger {
+
+	if logger != nil {
+		return logger
+	}
 
 	log := logrus.New()
 
@@ -10,10 +10,13 @@ type Payload struct {
 	Label         string                     `json:"label,omitempty"`
 	Serialization string                     `json:"serialization,omitempty"`
 	Reliable      bool                       `json:"reliable,omitempty"`
+	ChunkSize     int                        `json:"chunkSize,omitempty"`
 	Candidate     *webrtc.ICECandidateInit   `json:"candidate,omitempty"`
 	SDP           *webrtc.SessionDescription `json:"sdp,omitempty"`
 	Browser       string                     `json:"browser,omitempty"`
 	Msg           string                     `json:"msg,omitempty"`
+	ID            string                     `json:"id,omitempty"`
+	Token         string                     `json:"token,omitempty"`
 }
 
 // IMessage message interface
@@ -2,13 +2,13 @@ package peer
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"runtime"
 
 	"github.com/muka/peerjs-go/enums"
 	"github.com/muka/peerjs-go/models"
 	"github.com/pion/webrtc/v3"
-	"github.com/sirupsen/logrus"
 )
 
 // DefaultBrowser is the browser name
@@ -26,7 +26,7 @@ func newWebrtcAPI(mediaEngine *webrtc.MediaEngine) *webrtc.API {
 func NewNegotiator(conn Connection, opts ConnectionOptions) *Negotiator {
 	return &Negotiator{
 		connection: conn,
-		log:        createLogger("negotiator", opts.Debug),
+		log:        createLogger("negotiator", opts.Debug, peerLogger(conn.GetProvider())),
 		webrtc:     newWebrtcAPI(opts.MediaEngine),
 	}
 }
@@ -34,7 +34,7 @@ func NewNegotiator(conn Connection, opts ConnectionOptions) *Negotiator {
 // Negotiator manages all negotiations between Peers
 type Negotiator struct {
 	connection Connection
-	log        *logrus.Entry
+	log        Logger
 	webrtc     *webrtc.API
 }
 
@@ -54,26 +54,15 @@ func (n *Negotiator) StartConnection(opts ConnectionOptions) error {
 	// Set the connection's PC.
 	n.connection.SetPeerConnection(peerConnection)
 
-	if n.connection.GetType() == enums.ConnectionTypeMedia && opts.Stream != nil {
-		for _, track := range opts.Stream.GetTracks() {
-			rtpSender, err := peerConnection.AddTrack(track.(webrtc.TrackLocal))
-			if err != nil {
-				n.log.Warn("Error adding track to connection:", err)
-			} else {
-				go n.listenForRTCPPackets(rtpSender)
-			}
-		}
-	}
-
 	// What do we need to do now?
 	if opts.Originator {
+		n.addTracks(peerConnection, opts)
+
 		if n.connection.GetType() == enums.ConnectionTypeData {
 
 			dataConnection := n.connection.(*DataConnection)
 
-			config := &webrtc.DataChannelInit{
-				Ordered: &opts.Reliable,
-			}
+			config := dataChannelConfig(opts)
 
 			dataChannel, err := peerConnection.CreateDataChannel(dataConnection.Label, config)
 			if err != nil {
@@ -85,8 +74,14 @@ func (n *Negotiator) StartConnection(opts ConnectionOptions) error {
 
 		n.makeOffer()
 	} else {
-		// OFFER
-		err = n.handleSDP(enums.ServerMessageTypeOffer, opts.SDP)
+		// OFFER, the local tracks are attached to the offered transceivers
+		// before answering
+		err = n.setRemoteDescription(enums.ServerMessageTypeOffer, opts.SDP)
+		if err != nil {
+			return err
+		}
+		n.addTracks(peerConnection, opts)
+		err = n.makeAnswer()
 		if err != nil {
 			return err
 		}
@@ -95,6 +90,85 @@ func (n *Negotiator) StartConnection(opts ConnectionOptions) error {
 	return nil
 }
 
+// addTracks add the tracks of the local stream to a media connection. When
+// answering, tracks of a kind not offered by the remote peer are skipped
+func (n *Negotiator) addTracks(peerConnection *webrtc.PeerConnection, opts ConnectionOptions) {
+	if n.connection.GetType() != enums.ConnectionTypeMedia {
+		return
+	}
+	tracks := []webrtc.TrackLocal{}
+	if opts.Stream != nil {
+		for _, track := range opts.Stream.GetTracks() {
+			trackLocal, ok := track.(webrtc.TrackLocal)
+			if !ok || trackLocal == nil {
+				n.log.Warnf("Skipping track %v, not a local track", track)
+				continue
+			}
+			tracks = append(tracks, trackLocal)
+		}
+	}
+
+	if opts.Originator && opts.Direction == webrtc.RTPTransceiverDirectionRecvonly {
+		n.addRecvonlyTransceivers(peerConnection, tracks)
+		return
+	}
+
+	for _, trackLocal := range tracks {
+		if !opts.Originator && !hasFreeTransceiver(peerConnection, trackLocal.Kind()) {
+			n.log.Warnf("Skipping %s track %s, not offered by %s", trackLocal.Kind(), trackLocal.ID(), n.connection.GetPeerID())
+			continue
+		}
+		var rtpSender *webrtc.RTPSender
+		var err error
+		if opts.Originator && opts.Direction == webrtc.RTPTransceiverDirectionSendonly {
+			var transceiver *webrtc.RTPTransceiver
+			transceiver, err = peerConnection.AddTransceiverFromTrack(trackLocal, webrtc.RTPTransceiverInit{
+				Direction: webrtc.RTPTransceiverDirectionSendonly,
+			})
+			if err == nil {
+				rtpSender = transceiver.Sender()
+			}
+		} else {
+			rtpSender, err = peerConnection.AddTrack(trackLocal)
+		}
+		if err != nil {
+			n.log.Warnf("Error adding track to connection: %s", err)
+		} else {
+			go n.listenForRTCPPackets(rtpSender)
+		}
+	}
+}
+
+// addRecvonlyTransceivers offer to receive a track for each kind of tracks,
+// audio and video if tracks is empty
+func (n *Negotiator) addRecvonlyTransceivers(peerConnection *webrtc.PeerConnection, tracks []webrtc.TrackLocal) {
+	kinds := []webrtc.RTPCodecType{}
+	for _, track := range tracks {
+		kinds = append(kinds, track.Kind())
+	}
+	if len(kinds) == 0 {
+		kinds = []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo}
+	}
+	for _, kind := range kinds {
+		_, err := peerConnection.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+		})
+		if err != nil {
+			n.log.Warnf("Error adding %s transceiver to connection: %s", kind, err)
+		}
+	}
+}
+
+// hasFreeTransceiver check if a transceiver of kind has no track to send yet
+func hasFreeTransceiver(peerConnection *webrtc.PeerConnection, kind webrtc.RTPCodecType) bool {
+	for _, transceiver := range peerConnection.GetTransceivers() {
+		if transceiver.Kind() == kind && transceiver.Sender() == nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (n *Negotiator) listenForRTCPPackets(rtpSender *webrtc.RTPSender) {
 	// Read incoming RTCP packets
 	// Before these packets are returned they are processed by interceptors.
@@ -107,6 +181,23 @@ func (n *Negotiator) listenForRTCPPackets(rtpSender *webrtc.RTPSender) {
 	}
 }
 
+// dataChannelConfig return the data channel configuration for the
+// reliability options
+func dataChannelConfig(opts ConnectionOptions) *webrtc.DataChannelInit {
+	if opts.Reliable {
+		ordered := true
+		return &webrtc.DataChannelInit{
+			Ordered: &ordered,
+		}
+	}
+	ordered := opts.Ordered
+	return &webrtc.DataChannelInit{
+		Ordered:           &ordered,
+		MaxRetransmits:    opts.MaxRetransmits,
+		MaxPacketLifeTime: opts.MaxPacketLifeTime,
+	}
+}
+
 // Start a PC
 func (n *Negotiator) startPeerConnection(connectionReadyForIce *bool) (*webrtc.PeerConnection, error) {
 
@@ -172,7 +263,9 @@ func (n *Negotiator) setupListeners(peerConnection *webrtc.PeerConnection, conne
 		}
 
 		err = provider.GetSocket().Send(res)
-		if err != nil {
+		if errors.Is(err, ErrSocketClosed) {
+			n.log.Debugf("OnICECandidate: Socket closed, candidate not sent")
+		} else if err != nil {
 			n.log.Errorf("OnICECandidate: Failed to send message: %s", err)
 		}
 
@@ -258,7 +351,7 @@ func (n *Negotiator) Cleanup() {
 
 	if n.connection.GetType() == enums.ConnectionTypeData {
 		dataConnection := n.connection.(*DataConnection)
-		dataChannel := dataConnection.DataChannel
+		dataChannel := dataConnection.dataChannel()
 
 		if dataChannel != nil {
 			dataChannelNotClosed = dataChannel.ReadyState() != webrtc.DataChannelStateClosed
@@ -271,6 +364,21 @@ func (n *Negotiator) Cleanup() {
 
 }
 
+// transformSDP apply the connection SDPTransform, or the Peer one if not set,
+// to the local description sent to the remote peer. The local description
+// itself is left untouched as pion accepts only the SDP it generated, see
+// TestSetLocalDescriptionRejectsTransformedSDP
+func (n *Negotiator) transformSDP(desc webrtc.SessionDescription) *webrtc.SessionDescription {
+	transform := n.connection.GetOptions().SDPTransform
+	if transform == nil && n.connection.GetProvider() != nil {
+		transform = n.connection.GetProvider().GetOptions().SDPTransform
+	}
+	if transform != nil {
+		desc.SDP = transform(desc.SDP)
+	}
+	return &desc
+}
+
 func (n *Negotiator) makeOffer() error {
 
 	peerConnection := n.connection.GetPeerConnection()
@@ -285,22 +393,16 @@ func (n *Negotiator) makeOffer() error {
 	})
 	if err != nil {
 		err1 := fmt.Errorf("makeOffer: Failed to create offer: %s", err)
-		n.log.Warn(err1)
+		n.log.Warnf("%s", err1)
 		provider.EmitError(enums.PeerErrorTypeWebRTC, err1)
 		return err
 	}
 	n.log.Debug("Created offer")
 
-	connOpts := n.connection.GetOptions()
-
-	if connOpts.SDPTransform != nil {
-		offer.SDP = connOpts.SDPTransform(offer.SDP)
-	}
-
 	err = peerConnection.SetLocalDescription(offer)
 	if err != nil {
 		err1 := fmt.Errorf("makeOffer: Failed to set local description: %s", err)
-		n.log.Warn(err1)
+		n.log.Warnf("%s", err1)
 		provider.EmitError(enums.PeerErrorTypeWebRTC, err1)
 		return err
 	}
@@ -311,7 +413,7 @@ func (n *Negotiator) makeOffer() error {
 		Type:         n.connection.GetType(),
 		ConnectionID: n.connection.GetID(),
 		Metadata:     n.connection.GetMetadata(),
-		SDP:          &offer,
+		SDP:          n.transformSDP(offer),
 		Browser:      DefaultBrowser,
 	}
 
@@ -320,6 +422,7 @@ func (n *Negotiator) makeOffer() error {
 		payload.Label = dataConnection.Label
 		payload.Reliable = dataConnection.Reliable
 		payload.Serialization = dataConnection.Serialization
+		payload.ChunkSize = dataConnection.opts.ChunkSize
 	}
 
 	msg := models.Message{
@@ -331,15 +434,19 @@ func (n *Negotiator) makeOffer() error {
 	raw, err := json.Marshal(msg)
 	if err != nil {
 		err1 := fmt.Errorf("makeOffer: Failed to marshal socket message: %s", err)
-		n.log.Warn(err1)
+		n.log.Warnf("%s", err1)
 		provider.EmitError(enums.PeerErrorTypeWebRTC, err1)
 		return err
 	}
 
 	err = provider.GetSocket().Send(raw)
+	if errors.Is(err, ErrSocketClosed) {
+		n.log.Warnf("makeOffer: Socket closed, offer not sent to %s", n.connection.GetPeerID())
+		return nil
+	}
 	if err != nil {
 		err1 := fmt.Errorf("makeOffer: Failed to send message: %s", err)
-		n.log.Warn(err1)
+		n.log.Warnf("%s", err1)
 		provider.EmitError(enums.PeerErrorTypeWebRTC, err1)
 		return err
 	}
@@ -359,22 +466,17 @@ func (n *Negotiator) makeAnswer() error {
 	})
 	if err != nil {
 		err1 := fmt.Errorf("makeAnswer: Failed to create answer: %s", err)
-		n.log.Warn(err1)
+		n.log.Warnf("%s", err1)
 		provider.EmitError(enums.PeerErrorTypeWebRTC, err1)
 		return err
 	}
 
 	n.log.Debug("Created answer.")
 
-	connOpts := n.connection.GetOptions()
-	if connOpts.SDPTransform != nil {
-		answer.SDP = connOpts.SDPTransform(answer.SDP)
-	}
-
 	err = peerConnection.SetLocalDescription(answer)
 	if err != nil {
 		err1 := fmt.Errorf("makeAnswer: Failed to set local description: %s", err)
-		n.log.Warn(err1)
+		n.log.Warnf("%s", err1)
 		provider.EmitError(enums.PeerErrorTypeWebRTC, err1)
 		return err
 	}
@@ -387,7 +489,7 @@ func (n *Negotiator) makeAnswer() error {
 		Payload: models.Payload{
 			Type:         n.connection.GetType(),
 			ConnectionID: n.connection.GetID(),
-			SDP:          &answer,
+			SDP:          n.transformSDP(answer),
 			Browser:      DefaultBrowser,
 		},
 	}
@@ -395,15 +497,19 @@ func (n *Negotiator) makeAnswer() error {
 	raw, err := json.Marshal(msg)
 	if err != nil {
 		err1 := fmt.Errorf("makeAnswer: Failed to marshal sockt message: %s", err)
-		n.log.Warn(err1)
+		n.log.Warnf("%s", err1)
 		provider.EmitError(enums.PeerErrorTypeWebRTC, err1)
 		return err
 	}
 
 	err = provider.GetSocket().Send(raw)
+	if errors.Is(err, ErrSocketClosed) {
+		n.log.Warnf("makeAnswer: Socket closed, answer not sent to %s", n.connection.GetPeerID())
+		return nil
+	}
 	if err != nil {
 		err1 := fmt.Errorf("makeAnswer: Failed to send message: %s", err)
-		n.log.Warn(err1)
+		n.log.Warnf("%s", err1)
 		provider.EmitError(enums.PeerErrorTypeWebRTC, err1)
 		return err
 	}
@@ -411,8 +517,8 @@ func (n *Negotiator) makeAnswer() error {
 	return nil
 }
 
-// Handle an SDP.
-func (n *Negotiator) handleSDP(sdpType string, sdp webrtc.SessionDescription) error {
+// setRemoteDescription set the SDP received from the remote peer
+func (n *Negotiator) setRemoteDescription(sdpType string, sdp webrtc.SessionDescription) error {
 
 	peerConnection := n.connection.GetPeerConnection()
 	provider := n.connection.GetProvider()
@@ -427,6 +533,16 @@ func (n *Negotiator) handleSDP(sdpType string, sdp webrtc.SessionDescription) er
 	}
 
 	n.log.Debugf(`Set remoteDescription:%s for:%s`, sdpType, n.connection.GetPeerID())
+	return nil
+}
+
+// Handle an SDP.
+func (n *Negotiator) handleSDP(sdpType string, sdp webrtc.SessionDescription) error {
+
+	err := n.setRemoteDescription(sdpType, sdp)
+	if err != nil {
+		return err
+	}
 
 	// sdpType == OFFER
 	if sdpType == enums.ServerMessageTypeOffer {
@@ -0,0 +1,45 @@
+package peer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+// pion accepts only the SDP it generated as local description, so the
+// SDPTransform is applied to the SDP sent to the remote peer
+func TestSetLocalDescriptionRejectsTransformedSDP(t *testing.T) {
+	transform := func(sdp string) string {
+		return strings.Replace(sdp, "s=-", "s=transformed", 1)
+	}
+
+	offerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	assert.NoError(t, err)
+	defer offerer.Close()
+	answerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	assert.NoError(t, err)
+	defer answerer.Close()
+
+	_, err = offerer.CreateDataChannel("data", nil)
+	assert.NoError(t, err)
+	offer, err := offerer.CreateOffer(nil)
+	assert.NoError(t, err)
+
+	transformed := offer
+	transformed.SDP = transform(offer.SDP)
+	assert.Error(t, offerer.SetLocalDescription(transformed))
+	assert.NoError(t, offerer.SetLocalDescription(offer))
+
+	// the remote peer accepts the transformed SDP
+	assert.NoError(t, answerer.SetRemoteDescription(transformed))
+	answer, err := answerer.CreateAnswer(nil)
+	assert.NoError(t, err)
+
+	transformed = answer
+	transformed.SDP = transform(answer.SDP)
+	assert.Error(t, answerer.SetLocalDescription(transformed))
+	assert.NoError(t, answerer.SetLocalDescription(answer))
+	assert.NoError(t, offerer.SetRemoteDescription(transformed))
+}
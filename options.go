@@ -1,6 +1,10 @@
 package peer
 
 import (
+	"crypto/tls"
+	"time"
+
+	"github.com/gorilla/websocket"
 	"github.com/muka/peerjs-go/enums"
 	"github.com/muka/peerjs-go/models"
 	"github.com/muka/peerjs-go/util"
@@ -31,7 +35,11 @@ func NewOptions() Options {
 			},
 			SDPSemantics: webrtc.SDPSemanticsUnifiedPlan,
 		},
-		Debug: 0,
+		Debug:                0,
+		Reconnect:            false,
+		ReconnectMaxAttempts: 5,
+		ReconnectBaseDelay:   time.Second,
+		MaxQueueSize:         100,
 	}
 }
 
@@ -43,13 +51,20 @@ type Options struct {
 	Host string
 	//Port Server port. Defaults to 443.
 	Port int
-	//PingInterval Ping interval in ms. Defaults to 5000.
+	//PingInterval Ping interval in ms. Defaults to 1000, DefaultPingInterval (5000) is used if not positive.
 	PingInterval int
-	//Path The path where your self-hosted PeerServer is running. Defaults to '/'.
+	//DisableHeartbeat do not send heartbeats to the server, eg. relying on transport keepalives. The server may evict clients not sending heartbeats within its AliveTimeout.
+	DisableHeartbeat bool
+	//Path The path where your self-hosted PeerServer is running, the server Path option. Defaults to '/'.
 	Path string
 	//Secure true if you're using SSL.
 	Secure bool
+	//TLSConfig TLS configuration used to connect to the server when Secure is true, eg. to trust a self-signed certificate. Defaults to the system configuration.
+	TLSConfig *tls.Config
+	//Dialer used to connect to the server in place of websocket.DefaultDialer, eg. to connect through a proxy or with a custom NetDialContext. TLSConfig is used if the dialer has no TLSClientConfig.
+	Dialer *websocket.Dialer
 	//Configuration hash passed to RTCPeerConnection. This hash contains any custom ICE/TURN server configuration. Defaults to { 'iceServers': [{ 'urls': 'stun:stun.l.google.com:19302' }], 'sdpSemantics': 'unified-plan' }
+	//The negotiator reads it from the Peer options when creating the PeerConnection of each DataConnection and MediaConnection.
 	Configuration webrtc.Configuration
 	// Debug
 	// Prints log messages depending on the debug level passed in. Defaults to 0.
@@ -60,6 +75,19 @@ type Options struct {
 	Debug int8
 	//Token a string to group peers
 	Token string
+	//Reconnect dial again the server when the websocket connection drops. Defaults to false.
+	Reconnect bool
+	//ReconnectMaxAttempts number of reconnection attempts before giving up. Defaults to 5.
+	ReconnectMaxAttempts int
+	//ReconnectBaseDelay delay before the first reconnection attempt, doubled after each failure. Defaults to 1s.
+	ReconnectBaseDelay time.Duration
+	//MaxQueueSize max number of messages queued while the server connection is not available. Set to 0 to disable queuing. Defaults to 100.
+	MaxQueueSize int
+	//Logger used in place of the default logrus logger, Debug is ignored when set.
+	Logger Logger
+	//SDPTransform modify the local offers and answers before they are sent, eg. to prefer a codec or limit the bandwidth. Overridden by the connection SDPTransform.
+	//Only the SDP sent to the remote peer is modified, the local description keeps the SDP generated by pion, which rejects modified local descriptions.
+	SDPTransform func(sdp string) string
 }
 
 // NewConnectionOptions return a ConnectionOptions with defaults
@@ -80,19 +108,38 @@ type ConnectionOptions struct {
 	Label string
 	// Metadata associated with the connection, passed in by whoever initiated the connection.
 	Metadata interface{}
-	// Serialization. "raw" is the default. PeerJS supports other options, like encodings for JSON objects, but those aren't supported by this library.
+	// Serialization of the data sent with DataConnection.Send: "raw" (default) or "none" send []byte and string as-is, "json" sends the JSON encoding and "binary" the js-binarypack encoding of the data, as PeerJS does.
+	// The remote peer uses the same serialization, received data are decoded to []byte or string for raw, to the json.Unmarshal types for json and to the util.Unpack types for binary.
 	Serialization string
 	// Reliable whether the underlying data channels should be reliable (e.g. for large file transfers) or not (e.g. for gaming or streaming). Defaults to false.
+	// A reliable channel is ordered and retransmits lost messages, ignoring Ordered, MaxRetransmits and MaxPacketLifeTime.
 	Reliable bool
+	// Ordered whether messages are delivered in order on an unreliable channel. Defaults to false.
+	Ordered bool
+	// MaxRetransmits max number of retransmissions of a message on an unreliable channel, nil for no limit. Can not be set with MaxPacketLifeTime.
+	MaxRetransmits *uint16
+	// MaxPacketLifeTime time in ms during which a message is retransmitted on an unreliable channel, nil for no limit. Can not be set with MaxRetransmits.
+	MaxPacketLifeTime *uint16
+	// ChunkSize split raw messages larger than ChunkSize bytes in chunks, reassembled by the remote peer. Chunks are not understood by JS PeerJS raw connections. Defaults to 0, disabled.
+	// The ChunkSize of the peer starting the connection is sent in the offer and used by both peers, chunks are parsed only if it is set.
+	ChunkSize int
+	// BufferedAmountLowThreshold a bufferedamountlow event is emitted when the bytes queued on the data channel drop to this value. Defaults to 0.
+	BufferedAmountLowThreshold uint64
+	// MaxBufferedAmount max bytes queued on the data channel, Send returns ErrBufferFull when a message would exceed it, right away for messages larger than MaxBufferedAmount. Defaults to 0, no limit.
+	MaxBufferedAmount uint64
+	// BlockOnBufferFull makes Send wait for the data channel buffer to drain below MaxBufferedAmount instead of returning ErrBufferFull.
+	BlockOnBufferFull bool
 	// Stream contains the reference to a media stream
 	Stream *MediaStream
+	// Direction of a media call: sendrecv (default), sendonly to send the Stream tracks without receiving or recvonly to receive tracks of the Stream tracks kinds, audio and video if the Stream is empty, without sending.
+	Direction webrtc.RTPTransceiverDirection
 	// Originator indicate if the originator
 	Originator bool
 	// SDP contains SDP information
 	SDP webrtc.SessionDescription
 	// Debug level for debug taken. See Options
 	Debug int8
-	// SDPTransform transformation function for SDP message
+	// SDPTransform transformation function for SDP message, applied to the SDP sent to the remote peer. See Options.SDPTransform
 	SDPTransform func(string) string
 	// MediaEngine override the default pion webrtc MediaEngine used in negotiating media channels. This allows you to specify your own supported media formats and parameters.
 	MediaEngine *webrtc.MediaEngine
@@ -100,6 +147,10 @@ type ConnectionOptions struct {
 
 // AnswerOption wraps answer options
 type AnswerOption struct {
-	// SDPTransform transformation function for SDP message
+	// SDPTransform transformation function for SDP message, applied to the SDP sent to the remote peer. See Options.SDPTransform
 	SDPTransform func(string) string
+	// Stream the local tracks to send, used in place of the track passed to Answer
+	Stream *MediaStream
+	// Direction recvonly to receive the remote tracks without sending local media, the same as answering without tracks. The answer follows the direction of the offer, sendonly and inactive are not supported.
+	Direction webrtc.RTPTransceiverDirection
 }
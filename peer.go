@@ -9,7 +9,6 @@ import (
 	"github.com/muka/peerjs-go/enums"
 	"github.com/muka/peerjs-go/models"
 	"github.com/pion/webrtc/v3"
-	"github.com/sirupsen/logrus"
 )
 
 // DefaultKey is the default API key
@@ -54,7 +53,7 @@ func NewPeer(id string, opts Options) (*Peer, error) {
 		}
 	}
 
-	p.log = createLogger(fmt.Sprintf("peer:%s", id), opts.Debug)
+	p.log = createLogger(fmt.Sprintf("peer:%s", id), opts.Debug, opts.Logger)
 
 	err := p.initialize(id)
 	if err != nil {
@@ -72,7 +71,7 @@ type Peer struct {
 	connections  map[string]map[string]Connection
 	api          API
 	socket       *Socket
-	log          *logrus.Entry
+	log          Logger
 	open         bool
 	destroyed    bool
 	disconnected bool
@@ -147,19 +146,29 @@ func (p *Peer) messageHandler(msg SocketEvent) {
 	payload := msg.Message.GetPayload()
 	switch msg.Message.GetType() {
 	case enums.ServerMessageTypeOpen:
+		// the server sends the credentials it generated
+		if payload.ID != "" {
+			p.ID = payload.ID
+		}
+		if payload.Token != "" {
+			p.opts.Token = payload.Token
+		}
 		p.lastServerID = p.ID
 		p.open = true
 		p.log.Debugf("Open session with id=%s", p.ID)
 		p.Emit(enums.PeerEventTypeOpen, p.ID)
 		break
 	case enums.ServerMessageTypeError:
-		if msg.Error == nil {
-			msg.Error = errors.New(payload.Msg)
+		serverErr, ok := msg.Error.(ServerError)
+		if !ok {
+			serverErr = newServerError(*msg.Message)
 		}
-		p.abort(enums.PeerErrorTypeServerError, msg.Error)
+		p.abort(serverErr.PeerErrorType(), serverErr)
 		break
 	case enums.ServerMessageTypeIDTaken: // The selected ID is taken.
 		p.abort(enums.PeerErrorTypeUnavailableID, fmt.Errorf("ID %s is taken", p.ID))
+		// the ID can not be reclaimed with Reconnect
+		p.Destroy()
 		break
 	case enums.ServerMessageTypeInvalidKey: // The given API key cannot be found.
 		p.abort(enums.PeerErrorTypeInvalidKey, fmt.Errorf("API KEY %s is invalid", p.opts.Key))
@@ -177,6 +186,12 @@ func (p *Peer) messageHandler(msg SocketEvent) {
 		break
 	case enums.ServerMessageTypeOffer:
 
+		if payload.SDP == nil {
+			p.log.Warnf("Received an offer without SDP from %s", peerID)
+			p.EmitError(enums.PeerErrorTypeWebRTC, fmt.Errorf("Offer from %s has no SDP", peerID))
+			return
+		}
+
 		// we should consider switching this to CALL/CONNECT, but this is the least breaking option.
 		connectionID := payload.ConnectionID
 		connection, ok := p.GetConnection(peerID, connectionID)
@@ -193,6 +208,7 @@ func (p *Peer) messageHandler(msg SocketEvent) {
 				ConnectionID: connectionID,
 				Payload:      payload,
 				Metadata:     payload.Metadata,
+				SDP:          *payload.SDP,
 			})
 			if err != nil {
 				p.log.Errorf("Cannot initialize MediaConnection: %s", err)
@@ -208,6 +224,7 @@ func (p *Peer) messageHandler(msg SocketEvent) {
 				Label:         payload.Label,
 				Serialization: payload.Serialization,
 				Reliable:      payload.Reliable,
+				ChunkSize:     payload.ChunkSize,
 				SDP:           *payload.SDP,
 			})
 			if err != nil {
@@ -324,7 +341,7 @@ func (p *Peer) Connect(peerID string, opts *ConnectionOptions) (*DataConnection,
 	}
 
 	if p.disconnected {
-		p.log.Warn(`
+		p.log.Warnf(`
 	  You cannot connect to a new Peer because you called .disconnect() on this Peer
 	  and ended your connection with the server. You can create a new Peer to reconnect,
 	  or call reconnect on this peer if you believe its ID to still be available`)
@@ -365,7 +382,7 @@ func (p *Peer) Call(peerID string, track webrtc.TrackLocal, opts *ConnectionOpti
 	}
 
 	if p.disconnected {
-		p.log.Warn("You cannot connect to a new Peer because you called .disconnect() on this Peer and ended your connection with the server. You can create a new Peer to reconnect")
+		p.log.Warnf("You cannot connect to a new Peer because you called .disconnect() on this Peer and ended your connection with the server. You can create a new Peer to reconnect")
 		err := errors.New("Cannot connect to new Peer after disconnecting from server")
 		p.EmitError(
 			enums.PeerErrorTypeDisconnected,
@@ -374,14 +391,22 @@ func (p *Peer) Call(peerID string, track webrtc.TrackLocal, opts *ConnectionOpti
 		return nil, err
 	}
 
-	if track == nil && opts.Stream != nil {
+	if opts.Direction == webrtc.RTPTransceiverDirectionInactive {
+		return nil, ErrDirectionNotSupported
+	}
+
+	receiveOnly := opts.Direction == webrtc.RTPTransceiverDirectionRecvonly
+	if track == nil && opts.Stream == nil && !receiveOnly {
 		err := errors.New("To call a peer, you must provide a stream")
-		p.log.Error(err)
+		p.log.Errorf("%s", err)
 		return nil, err
 	}
 
 	if opts.Stream == nil {
-		opts.Stream = NewMediaStreamWithTrack([]MediaStreamTrack{track})
+		opts.Stream = NewMediaStreamWithTrack([]MediaStreamTrack{})
+		if track != nil {
+			opts.Stream.AddTrack(track)
+		}
 	}
 
 	mediaConnection, err := NewMediaConnection(peerID, p, *opts)
@@ -394,7 +419,7 @@ func (p *Peer) Call(peerID string, track webrtc.TrackLocal, opts *ConnectionOpti
 }
 
 func (p *Peer) abort(errType string, err error) error {
-	p.log.Error("Aborting!")
+	p.log.Errorf("Aborting!")
 	p.EmitError(errType, err)
 	p.Close()
 	return err
@@ -465,7 +490,7 @@ func (p *Peer) cleanupPeer(peerID string) {
 // Disconnect disconnects the Peer's connection to the PeerServer. Does not close any
 // active connections.
 // Warning: The peer can no longer create or accept connections after being
-// disconnected. It also cannot reconnect to the server.
+// disconnected, until Reconnect is called.
 func (p *Peer) Disconnect() {
 	if p.disconnected {
 		return
@@ -488,13 +513,20 @@ func (p *Peer) Disconnect() {
 	p.Emit(enums.PeerEventTypeDisconnected, currentID)
 }
 
-// Reconnect Attempts to reconnect with the same ID
+// Reconnect Attempts to reconnect with the same ID and token. An open event is
+// emitted once the server accepts the connection
 func (p *Peer) Reconnect() error {
 
 	if p.disconnected && !p.destroyed {
 		p.log.Debugf(`Attempting reconnection to server with ID %s`, p.lastServerID)
 		p.disconnected = false
-		p.initialize(p.lastServerID)
+		err := p.initialize(p.lastServerID)
+		if err != nil {
+			p.unregisterSocketHandlers()
+			p.disconnected = true
+			p.ID = ""
+			return err
+		}
 		return nil
 	}
 
@@ -504,7 +536,7 @@ func (p *Peer) Reconnect() error {
 
 	if !p.disconnected && !p.open {
 		// Do nothing. We're still connecting the first time.
-		p.log.Error("In a hurry? We're still trying to make the initial connection!")
+		p.log.Errorf("In a hurry? We're still trying to make the initial connection!")
 		return nil
 	}
 
@@ -514,18 +546,19 @@ func (p *Peer) Reconnect() error {
 // ListAllPeers Get a list of available peer IDs. If you're running your own server, you'll
 // want to set allow_discovery: true in the PeerServer options. If you're using
 // the cloud server, email team@peerjs.com to get the functionality enabled for
-// your key.
+// your key. ErrPeerDiscoveryDisabled is returned if the server does not allow
+// discovery.
 func (p *Peer) ListAllPeers() ([]string, error) {
 
 	peers := []string{}
 	raw, err := p.api.ListAllPeers()
 	if err != nil {
-		return peers, p.abort(enums.PeerErrorTypeServerError, err)
+		return peers, PeerError{Type: enums.PeerErrorTypeServerError, Err: err}
 	}
 
 	err = json.Unmarshal(raw, &peers)
 	if err != nil {
-		return peers, p.abort(enums.PeerErrorTypeServerError, err)
+		return peers, PeerError{Type: enums.PeerErrorTypeServerError, Err: err}
 	}
 
 	return peers, nil
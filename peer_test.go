@@ -2,13 +2,18 @@ package peer
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/muka/peerjs-go/enums"
+	"github.com/muka/peerjs-go/models"
 	"github.com/muka/peerjs-go/server"
 	"github.com/muka/peerjs-go/util"
+	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 	"github.com/stretchr/testify/assert"
 )
@@ -114,6 +119,52 @@ func TestDuplicatedID(t *testing.T) {
 	<-time.After(time.Second * 1)
 }
 
+func TestPeerIDTaken(t *testing.T) {
+
+	peerName := rndName("taken")
+
+	peerServer, serverOpts := startServer()
+	err := peerServer.Start()
+	if err != nil {
+		t.Logf("Server error: %s", err)
+		t.FailNow()
+	}
+	defer peerServer.Stop()
+
+	peer1, err := NewPeer(peerName, getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer1.Close()
+
+	peerErrors := make(chan PeerError, 1)
+	closed := make(chan bool, 1)
+	peer2, err := NewPeer(peerName, getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer2.Close()
+	peer2.On("error", func(raw interface{}) {
+		peerErrors <- raw.(PeerError)
+	})
+	peer2.On("close", func(raw interface{}) {
+		closed <- true
+	})
+
+	select {
+	case peerErr := <-peerErrors:
+		assert.Equal(t, enums.PeerErrorTypeUnavailableID, peerErr.Type)
+		assert.Contains(t, peerErr.Error(), "taken")
+	case <-time.After(time.Second * 2):
+		t.Fatal("unavailable-id error not emitted")
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 2):
+		t.Fatal("peer not closed")
+	}
+
+	// the id can not be claimed again by the rejected peer
+	assert.Error(t, peer2.Reconnect())
+	assert.False(t, peer1.GetDestroyed())
+}
+
 func TestHelloWorld(t *testing.T) {
 
 	peer1Name := rndName("peer1")
@@ -221,6 +272,34 @@ func TestLongPayload(t *testing.T) {
 	<-done
 }
 
+// writeRTP write RTP packets to track until done is closed
+func writeRTP(track *webrtc.TrackLocalStaticRTP, done chan bool) {
+	packet := &rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 96},
+		Payload: []byte{0x00, 0x01, 0x02, 0x03},
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(time.Millisecond * 20):
+			packet.SequenceNumber++
+			packet.Timestamp += 900
+			track.WriteRTP(packet)
+		}
+	}
+}
+
+// readRemoteTrack read a RTP packet from the remote track emitted by a stream event
+func readRemoteTrack(received chan webrtc.RTPCodecType) func(interface{}) {
+	return func(raw interface{}) {
+		tr := raw.(*webrtc.TrackRemote)
+		if _, _, err := tr.ReadRTP(); err == nil {
+			received <- tr.Kind()
+		}
+	}
+}
+
 func TestMediaCall(t *testing.T) {
 
 	peer1Name := rndName("peer1")
@@ -242,29 +321,783 @@ func TestMediaCall(t *testing.T) {
 	assert.NoError(t, err)
 	defer peer2.Close()
 
-	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: "video/vp8"}, "video", "pion")
+	track1, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: "video/vp8"}, "video", "peer1")
+	assert.NoError(t, err)
+	track2, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: "video/vp8"}, "video", "peer2")
+	assert.NoError(t, err)
+
+	done := make(chan bool)
+	defer close(done)
+	go writeRTP(track1, done)
+	go writeRTP(track2, done)
+
+	received1 := make(chan webrtc.RTPCodecType, 1)
+	received2 := make(chan webrtc.RTPCodecType, 1)
+
+	peer2.On("call", func(raw interface{}) {
+		// Answer the call, providing our track
+		call := raw.(*MediaConnection)
+		call.On("stream", readRemoteTrack(received2))
+		assert.NoError(t, call.Answer(track2, nil))
+		assert.ErrorIs(t, call.Answer(track2, nil), ErrAlreadyAnswered)
+	})
+
+	call1, err := peer1.Call(peer2Name, track1, nil)
+	assert.NoError(t, err)
+	call1.On("stream", readRemoteTrack(received1))
+
+	for _, received := range []chan webrtc.RTPCodecType{received1, received2} {
+		select {
+		case kind := <-received:
+			assert.Equal(t, webrtc.RTPCodecTypeVideo, kind)
+		case <-time.After(time.Second * 10):
+			t.Fatal("remote track not received")
+		}
+	}
+	assert.Len(t, call1.GetRemoteStream().GetTracks(), 1)
+}
+
+func TestMediaCallReceiveOnly(t *testing.T) {
+
+	peer2Name := rndName("peer2")
+
+	peerServer, serverOpts := startServer()
+	err := peerServer.Start()
 	if err != nil {
-		panic(err)
+		t.Logf("Server error: %s", err)
+		t.FailNow()
 	}
+	defer peerServer.Stop()
+
+	peer1, err := NewPeer(rndName("peer1"), getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer1.Close()
+
+	peer2, err := NewPeer(peer2Name, getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer2.Close()
 
-	call1, err := peer1.Call(peer2Name, track, nil)
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: "audio/opus"}, "audio", "peer1")
 	assert.NoError(t, err)
 
+	done := make(chan bool)
+	defer close(done)
+	go writeRTP(track, done)
+
+	received := make(chan webrtc.RTPCodecType, 1)
+	answered := make(chan *MediaConnection, 1)
 	peer2.On("call", func(raw interface{}) {
-		// Answer the call, providing our mediaStream
-		call := raw.(MediaConnection)
-		var mediaStream webrtc.TrackLocal
-
-		call.Answer(mediaStream, nil)
-		call.On("stream", func(raw interface{}) {
-			// stream := raw.(MediaStream)
-			t.Log("peer2: Received remote stream")
+		// Answer without local media, only receiving the caller audio
+		call := raw.(*MediaConnection)
+		call.On("stream", readRemoteTrack(received))
+		assert.NoError(t, call.Answer(nil, nil))
+		answered <- call
+	})
+
+	_, err = peer1.Call(peer2Name, track, nil)
+	assert.NoError(t, err)
+
+	select {
+	case kind := <-received:
+		assert.Equal(t, webrtc.RTPCodecTypeAudio, kind)
+	case <-time.After(time.Second * 10):
+		t.Fatal("remote track not received")
+	}
+	call2 := <-answered
+	assert.Empty(t, call2.GetLocalStream().GetTracks())
+}
+
+// testCallDirection call with opts, answering with answerTrack, and check the
+// receiving side gets an audio track and the offer has the expected direction
+func testCallDirection(t *testing.T, opts *ConnectionOptions, callTrack, answerTrack *webrtc.TrackLocalStaticRTP, expected string) {
+	peer2Name := rndName("peer2")
+
+	peerServer, serverOpts := startServer()
+	err := peerServer.Start()
+	if err != nil {
+		t.Logf("Server error: %s", err)
+		t.FailNow()
+	}
+	defer peerServer.Stop()
+
+	peer1, err := NewPeer(rndName("peer1"), getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer1.Close()
+
+	peer2, err := NewPeer(peer2Name, getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer2.Close()
+
+	received := make(chan webrtc.RTPCodecType, 1)
+	peer2.On("call", func(raw interface{}) {
+		call := raw.(*MediaConnection)
+		call.On("stream", readRemoteTrack(received))
+		var tl webrtc.TrackLocal
+		if answerTrack != nil {
+			tl = answerTrack
+		}
+		assert.NoError(t, call.Answer(tl, nil))
+	})
+
+	var tl webrtc.TrackLocal
+	if callTrack != nil {
+		tl = callTrack
+	}
+	call1, err := peer1.Call(peer2Name, tl, opts)
+	assert.NoError(t, err)
+	call1.On("stream", readRemoteTrack(received))
+
+	select {
+	case kind := <-received:
+		assert.Equal(t, webrtc.RTPCodecTypeAudio, kind)
+	case <-time.After(time.Second * 10):
+		t.Fatal("remote track not received")
+	}
+	assert.Contains(t, call1.GetPeerConnection().LocalDescription().SDP, expected)
+}
+
+func TestMediaCallDirection(t *testing.T) {
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: "audio/opus"}, "audio", "peer")
+	assert.NoError(t, err)
+	done := make(chan bool)
+	defer close(done)
+	go writeRTP(track, done)
+
+	t.Run("recvonly", func(t *testing.T) {
+		opts := NewConnectionOptions()
+		opts.Direction = webrtc.RTPTransceiverDirectionRecvonly
+		testCallDirection(t, opts, nil, track, "a=recvonly")
+	})
+	t.Run("sendonly", func(t *testing.T) {
+		opts := NewConnectionOptions()
+		opts.Direction = webrtc.RTPTransceiverDirectionSendonly
+		testCallDirection(t, opts, track, nil, "a=sendonly")
+	})
+}
+
+func TestMediaCallDirectionNotSupported(t *testing.T) {
+	m := &MediaConnection{}
+	err := m.Answer(nil, &AnswerOption{Direction: webrtc.RTPTransceiverDirectionSendonly})
+	assert.ErrorIs(t, err, ErrDirectionNotSupported)
+
+	peerServer, serverOpts := startServer()
+	err = peerServer.Start()
+	if err != nil {
+		t.Logf("Server error: %s", err)
+		t.FailNow()
+	}
+	defer peerServer.Stop()
+
+	peer1, err := NewPeer(rndName("peer1"), getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer1.Close()
+	opts := NewConnectionOptions()
+	opts.Direction = webrtc.RTPTransceiverDirectionInactive
+	_, err = peer1.Call("remote", nil, opts)
+	assert.ErrorIs(t, err, ErrDirectionNotSupported)
+}
+
+func TestPeerOfferWithoutSDP(t *testing.T) {
+	peerServer, serverOpts := startServer()
+	err := peerServer.Start()
+	if err != nil {
+		t.Logf("Server error: %s", err)
+		t.FailNow()
+	}
+	defer peerServer.Stop()
+
+	peer1, err := NewPeer(rndName("peer1"), getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer1.Close()
+
+	peerErrors := make(chan PeerError, 2)
+	peer1.On("error", func(raw interface{}) {
+		peerErrors <- raw.(PeerError)
+	})
+
+	for _, connectionType := range []string{enums.ConnectionTypeMedia, enums.ConnectionTypeData} {
+		peer1.messageHandler(SocketEvent{
+			Type: enums.SocketEventTypeMessage,
+			Message: &models.Message{
+				Type: enums.ServerMessageTypeOffer,
+				Src:  "remote",
+				Payload: models.Payload{
+					Type:         connectionType,
+					ConnectionID: "conn_" + connectionType,
+				},
+			},
+		})
+		peerErr := <-peerErrors
+		assert.Equal(t, enums.PeerErrorTypeWebRTC, peerErr.Type)
+		_, ok := peer1.GetConnection("remote", "conn_"+connectionType)
+		assert.False(t, ok)
+	}
+}
+
+func TestICEServersConfiguration(t *testing.T) {
+	peerServer, serverOpts := startServer()
+	err := peerServer.Start()
+	if err != nil {
+		t.Logf("Server error: %s", err)
+		t.FailNow()
+	}
+	defer peerServer.Stop()
+
+	assert.Equal(t, []string{"stun:stun.l.google.com:19302"}, NewOptions().Configuration.ICEServers[0].URLs)
+
+	iceServers := []webrtc.ICEServer{
+		{
+			URLs: []string{"stun:stun.example.com:3478"},
+		},
+		{
+			URLs:           []string{"turn:turn.example.com:3478"},
+			Username:       "user",
+			Credential:     "secret",
+			CredentialType: webrtc.ICECredentialTypePassword,
+		},
+	}
+	opts := getTestOpts(serverOpts)
+	opts.Configuration.ICEServers = iceServers
+
+	peer1, err := NewPeer(rndName("ice"), opts)
+	assert.NoError(t, err)
+	defer peer1.Close()
+
+	conn, err := peer1.Connect(rndName("remote"), nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, iceServers, conn.GetPeerConnection().GetConfiguration().ICEServers)
+}
+
+func TestPeerLeaveClosesConnections(t *testing.T) {
+	peerServer, serverOpts := startServer()
+	err := peerServer.Start()
+	if err != nil {
+		t.Logf("Server error: %s", err)
+		t.FailNow()
+	}
+	defer peerServer.Stop()
+
+	peer2Name := rndName("peer2")
+
+	peer1, err := NewPeer(rndName("peer1"), getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer1.Close()
+
+	peer2, err := NewPeer(peer2Name, getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer2.Close()
+
+	opened := make(chan *DataConnection, 1)
+	closed := make(chan bool, 1)
+	peer2.On("connection", func(data interface{}) {
+		conn2 := data.(*DataConnection)
+		conn2.On("open", func(data interface{}) {
+			opened <- conn2
+		})
+		conn2.On("close", func(data interface{}) {
+			closed <- true
 		})
 	})
 
-	call1.On("stream", func(raw interface{}) {
-		// stream := raw.(MediaStream)
-		t.Log("peer1: Received remote stream")
+	_, err = peer1.Connect(peer2Name, nil)
+	assert.NoError(t, err)
+
+	select {
+	case <-opened:
+	case <-time.After(time.Second * 10):
+		t.Fatal("connection not open")
+	}
+
+	// the connection is left open, the server sends LEAVE to peer2
+	peer1.Disconnect()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("connection not closed on LEAVE")
+	}
+}
+
+func TestUnreliableDataConnection(t *testing.T) {
+	peerServer, serverOpts := startServer()
+	err := peerServer.Start()
+	if err != nil {
+		t.Logf("Server error: %s", err)
+		t.FailNow()
+	}
+	defer peerServer.Stop()
+
+	peer2Name := rndName("peer2")
+
+	peer1, err := NewPeer(rndName("peer1"), getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer1.Close()
+
+	peer2, err := NewPeer(peer2Name, getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer2.Close()
+
+	remoteOpen := make(chan *DataConnection, 1)
+	peer2.On("connection", func(data interface{}) {
+		conn2 := data.(*DataConnection)
+		conn2.On("open", func(data interface{}) {
+			remoteOpen <- conn2
+		})
 	})
 
+	maxRetransmits := uint16(0)
+	opts := NewConnectionOptions()
+	opts.Reliable = false
+	opts.Ordered = false
+	opts.MaxRetransmits = &maxRetransmits
+
+	conn1, err := peer1.Connect(peer2Name, opts)
+	assert.NoError(t, err)
+	localOpen := make(chan bool, 1)
+	conn1.On("open", func(data interface{}) {
+		localOpen <- true
+	})
+
+	var conn2 *DataConnection
+	select {
+	case conn2 = <-remoteOpen:
+	case <-time.After(time.Second * 10):
+		t.Fatal("remote data connection not open")
+	}
+	select {
+	case <-localOpen:
+	case <-time.After(time.Second * 10):
+		t.Fatal("local data connection not open")
+	}
+
+	for _, dc := range []*webrtc.DataChannel{conn1.DataChannel, conn2.DataChannel} {
+		assert.False(t, dc.Ordered())
+		if assert.NotNil(t, dc.MaxRetransmits()) {
+			assert.Equal(t, uint16(0), *dc.MaxRetransmits())
+		}
+		assert.Nil(t, dc.MaxPacketLifeTime())
+	}
+}
+
+func TestChunkedPayload(t *testing.T) {
+	peerServer, serverOpts := startServer()
+	err := peerServer.Start()
+	if err != nil {
+		t.Logf("Server error: %s", err)
+		t.FailNow()
+	}
+	defer peerServer.Stop()
+
+	peer2Name := rndName("peer2")
+
+	peer1, err := NewPeer(rndName("peer1"), getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer1.Close()
+
+	peer2, err := NewPeer(peer2Name, getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer2.Close()
+
+	received := make(chan []byte, 1)
+	peer2.On("connection", func(data interface{}) {
+		conn2 := data.(*DataConnection)
+		conn2.On("data", func(data interface{}) {
+			received <- data.([]byte)
+		})
+	})
+
+	raw := bytes.Repeat([]byte("test"), 100000)
+	opts := NewConnectionOptions()
+	opts.Reliable = true
+	opts.ChunkSize = util.ChunkedMTU
+	conn1, err := peer1.Connect(peer2Name, opts)
+	assert.NoError(t, err)
+	conn1.On("open", func(data interface{}) {
+		assert.NoError(t, conn1.Send(raw, false))
+	})
+
+	select {
+	case data := <-received:
+		assert.Equal(t, raw, data)
+	case <-time.After(time.Second * 10):
+		t.Fatal("chunked payload not received")
+	}
+}
+
+func TestDataConnectionSerialization(t *testing.T) {
+	peerServer, serverOpts := startServer()
+	err := peerServer.Start()
+	if err != nil {
+		t.Logf("Server error: %s", err)
+		t.FailNow()
+	}
+	defer peerServer.Stop()
+
+	peer2Name := rndName("peer2")
+
+	peer1, err := NewPeer(rndName("peer1"), getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer1.Close()
+
+	peer2, err := NewPeer(peer2Name, getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer2.Close()
+
+	received := make(chan interface{}, 10)
+	peer2.On("connection", func(data interface{}) {
+		conn2 := data.(*DataConnection)
+		conn2.On("data", func(data interface{}) {
+			received <- data
+		})
+	})
+
+	large := bytes.Repeat([]byte("large"), util.ChunkedMTU)
+	tests := []struct {
+		serialization string
+		sent          interface{}
+		expected      interface{}
+	}{
+		{enums.SerializationTypeJSON, map[string]interface{}{"n": 1}, map[string]interface{}{"n": float64(1)}},
+		{enums.SerializationTypeBinary, map[string]interface{}{"n": 1}, map[string]interface{}{"n": int64(1)}},
+		{enums.SerializationTypeBinary, large, large},
+		{enums.SerializationTypeNone, []byte("raw"), []byte("raw")},
+	}
+
+	for _, test := range tests {
+		opts := NewConnectionOptions()
+		opts.Reliable = true
+		opts.Serialization = test.serialization
+		conn1, err := peer1.Connect(peer2Name, opts)
+		assert.NoError(t, err)
+		open := make(chan bool, 1)
+		conn1.On("open", func(data interface{}) {
+			open <- true
+		})
+		select {
+		case <-open:
+		case <-time.After(time.Second * 10):
+			t.Fatalf("%s connection not open", test.serialization)
+		}
+
+		assert.NoError(t, conn1.Send(test.sent, false))
+		select {
+		case data := <-received:
+			assert.Equal(t, test.expected, data, test.serialization)
+		case <-time.After(time.Second * 10):
+			t.Fatalf("%s data not received", test.serialization)
+		}
+		conn1.Close()
+	}
+}
+
+func TestPeerReconnect(t *testing.T) {
+	peerServer, serverOpts := startServer()
+	err := peerServer.Start()
+	if err != nil {
+		t.Logf("Server error: %s", err)
+		t.FailNow()
+	}
+	defer peerServer.Stop()
+
+	peerName := rndName("reconnect")
+	p, err := NewPeer(peerName, getTestOpts(serverOpts))
+	assert.NoError(t, err)
+
+	open := make(chan string, 1)
+	p.On(enums.PeerEventTypeOpen, func(data interface{}) {
+		open <- data.(string)
+	})
+	disconnected := make(chan string, 1)
+	p.On(enums.PeerEventTypeDisconnected, func(data interface{}) {
+		disconnected <- data.(string)
+	})
+
+	select {
+	case <-open:
+	case <-time.After(time.Second * 5):
+		t.Fatal("peer not open")
+	}
+
+	// drop the connection without a close handshake
+	p.GetSocket().conn.UnderlyingConn().Close()
+
+	select {
+	case id := <-disconnected:
+		assert.Equal(t, peerName, id)
+	case <-time.After(time.Second * 5):
+		t.Fatal("disconnected not emitted")
+	}
+	assert.True(t, p.GetDisconnected())
+
+	err = p.Reconnect()
+	assert.NoError(t, err)
+	select {
+	case id := <-open:
+		assert.Equal(t, peerName, id)
+	case <-time.After(time.Second * 5):
+		t.Fatal("peer not open after reconnect")
+	}
+	assert.Equal(t, peerName, p.ID)
+	assert.True(t, p.GetOpen())
+	assert.False(t, p.GetDisconnected())
+
+	p.Destroy()
+	assert.Error(t, p.Reconnect())
+}
+
+func TestPeerServerGeneratedToken(t *testing.T) {
+	serverOpts := server.NewOptions()
+	serverOpts.Port = 9000
+	serverOpts.Host = "localhost"
+	serverOpts.Path = "/myapp"
+	serverOpts.LogLevel = "debug"
+	serverOpts.AllowServerGeneratedIDs = true
+	peerServer := server.New(serverOpts)
+	err := peerServer.Start()
+	if err != nil {
+		t.Logf("Server error: %s", err)
+		t.FailNow()
+	}
+	defer peerServer.Stop()
+
+	peerName := rndName("generated")
+	opts := getTestOpts(serverOpts)
+	opts.Token = ""
+	p, err := NewPeer(peerName, opts)
+	assert.NoError(t, err)
+	defer p.Destroy()
+
+	open := make(chan string, 1)
+	p.On(enums.PeerEventTypeOpen, func(data interface{}) {
+		open <- data.(string)
+	})
+	select {
+	case id := <-open:
+		assert.Equal(t, peerName, id)
+	case <-time.After(time.Second * 5):
+		t.Fatal("peer not open")
+	}
+	assert.NotEmpty(t, p.opts.Token)
+
+	// the generated token is used to reclaim the id
+	p.Disconnect()
+	err = p.Reconnect()
+	assert.NoError(t, err)
+	select {
+	case id := <-open:
+		assert.Equal(t, peerName, id)
+	case <-time.After(time.Second * 5):
+		t.Fatal("peer not open after reconnect")
+	}
+	assert.Equal(t, peerName, p.ID)
+}
+
+func TestDataConnectionBackpressure(t *testing.T) {
+	peerServer, serverOpts := startServer()
+	err := peerServer.Start()
+	if err != nil {
+		t.Logf("Server error: %s", err)
+		t.FailNow()
+	}
+	defer peerServer.Stop()
+
+	peer2Name := rndName("peer2")
+
+	peer1, err := NewPeer(rndName("peer1"), getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer1.Close()
+
+	peer2, err := NewPeer(peer2Name, getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer2.Close()
+
+	const messages = 64
+	const size = 32 * 1024
+
+	done := make(chan int, 1)
+	peer2.On("connection", func(data interface{}) {
+		conn2 := data.(*DataConnection)
+		received := 0
+		conn2.On("data", func(data interface{}) {
+			received += len(data.([]byte))
+			if received == messages*size {
+				done <- received
+			}
+		})
+	})
+
+	opts := NewConnectionOptions()
+	opts.Reliable = true
+	opts.BufferedAmountLowThreshold = size
+	opts.MaxBufferedAmount = size * 4
+	opts.BlockOnBufferFull = true
+	conn1, err := peer1.Connect(peer2Name, opts)
+	assert.NoError(t, err)
+
+	open := make(chan bool, 1)
+	conn1.On("open", func(data interface{}) {
+		open <- true
+	})
+	select {
+	case <-open:
+	case <-time.After(time.Second * 10):
+		t.Fatal("connection not open")
+	}
+
+	payload := bytes.Repeat([]byte("b"), size)
+	for i := 0; i < messages; i++ {
+		assert.NoError(t, conn1.Send(payload, false))
+		assert.LessOrEqual(t, conn1.BufferedAmount(), opts.MaxBufferedAmount)
+	}
+
+	select {
+	case received := <-done:
+		assert.Equal(t, messages*size, received)
+	case <-time.After(time.Second * 10):
+		t.Fatal("data not received")
+	}
+
+	// a message larger than MaxBufferedAmount never fits, even blocking
+	sent := make(chan error, 1)
+	go func() {
+		sent <- conn1.Send(bytes.Repeat([]byte("b"), size*5), false)
+	}()
+	select {
+	case err := <-sent:
+		assert.Equal(t, ErrBufferFull, err)
+	case <-time.After(time.Second * 2):
+		t.Fatal("oversized message blocked Send")
+	}
+}
+
+func TestListAllPeers(t *testing.T) {
+	serverOpts := server.NewOptions()
+	serverOpts.Port = 9000
+	serverOpts.Host = "localhost"
+	serverOpts.Path = "/myapp"
+	serverOpts.AllowDiscovery = true
+	peerServer := server.New(serverOpts)
+	err := peerServer.Start()
+	if err != nil {
+		t.Logf("Server error: %s", err)
+		t.FailNow()
+	}
+	defer peerServer.Stop()
+
+	peer1Name := rndName("peer1")
+	peer1, err := NewPeer(peer1Name, getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer1.Close()
+
+	peer2Name := rndName("peer2")
+	peer2, err := NewPeer(peer2Name, getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer peer2.Close()
+
+	assert.Eventually(t, func() bool {
+		peers, err := peer1.ListAllPeers()
+		return err == nil && len(peers) == 2
+	}, time.Second*2, time.Millisecond*50)
+
+	peers, err := peer1.ListAllPeers()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{peer1Name, peer2Name}, peers)
+}
+
+func TestListAllPeersDiscoveryDisabled(t *testing.T) {
+	peerServer, serverOpts := startServer()
+	err := peerServer.Start()
+	if err != nil {
+		t.Logf("Server error: %s", err)
+		t.FailNow()
+	}
+	defer peerServer.Stop()
+
+	p, err := NewPeer(rndName("peer"), getTestOpts(serverOpts))
+	assert.NoError(t, err)
+	defer p.Close()
+
+	_, err = p.ListAllPeers()
+	assert.True(t, errors.Is(err, ErrPeerDiscoveryDisabled))
+	// the peer is still usable
+	assert.False(t, p.GetDestroyed())
+	assert.NotEmpty(t, p.ID)
+}
+
+func TestPeerSDPTransform(t *testing.T) {
+	peerServer, serverOpts := startServer()
+	err := peerServer.Start()
+	if err != nil {
+		t.Logf("Server error: %s", err)
+		t.FailNow()
+	}
+	defer peerServer.Stop()
+
+	peer2Name := rndName("peer2")
+
+	opts1 := getTestOpts(serverOpts)
+	opts1.SDPTransform = func(sdp string) string {
+		return strings.Replace(sdp, "s=-", "s=offer", 1)
+	}
+	peer1, err := NewPeer(rndName("peer1"), opts1)
+	assert.NoError(t, err)
+	defer peer1.Close()
+
+	opts2 := getTestOpts(serverOpts)
+	opts2.SDPTransform = func(sdp string) string {
+		return strings.Replace(sdp, "s=-", "s=answer", 1)
+	}
+	peer2, err := NewPeer(peer2Name, opts2)
+	assert.NoError(t, err)
+	defer peer2.Close()
+
+	conn2 := make(chan *DataConnection, 1)
+	peer2.On("connection", func(data interface{}) {
+		conn2 <- data.(*DataConnection)
+	})
+
+	conn1, err := peer1.Connect(peer2Name, nil)
+	assert.NoError(t, err)
+
+	open := make(chan bool, 1)
+	conn1.On("open", func(data interface{}) {
+		open <- true
+	})
+	select {
+	case <-open:
+	case <-time.After(time.Second * 10):
+		t.Fatal("connection not open")
+	}
+
+	assert.Contains(t, conn1.GetPeerConnection().RemoteDescription().SDP, "s=answer")
+	assert.Contains(t, (<-conn2).GetPeerConnection().RemoteDescription().SDP, "s=offer")
+}
+
+func TestPeerServerMountPath(t *testing.T) {
+	serverOpts := server.NewOptions()
+	serverOpts.Port = 9000
+	serverOpts.Host = "localhost"
+	serverOpts.Path = "/signaling/v1/"
+	serverOpts.AllowDiscovery = true
+	peerServer := server.New(serverOpts)
+	err := peerServer.Start()
+	if err != nil {
+		t.Logf("Server error: %s", err)
+		t.FailNow()
+	}
+	defer peerServer.Stop()
+
+	opts := getTestOpts(serverOpts)
+	opts.Path = "signaling/v1"
+	peerName := rndName("peer")
+	p, err := NewPeer(peerName, opts)
+	assert.NoError(t, err)
+	defer p.Close()
+
+	assert.Eventually(t, func() bool {
+		peers, err := p.ListAllPeers()
+		return err == nil && len(peers) == 1 && peers[0] == peerName
+	}, time.Second*2, time.Millisecond*50)
+	assert.True(t, p.GetOpen())
 }
@@ -5,7 +5,6 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
-	"github.com/sirupsen/logrus"
 )
 
 // NewAuth init a new Auth middleware
@@ -20,7 +19,7 @@ func NewAuth(realm IRealm, opts Options) *Auth {
 // Auth handles request authentication
 type Auth struct {
 	opts  Options
-	log   *logrus.Entry
+	log   Logger
 	realm IRealm
 }
 
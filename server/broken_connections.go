@@ -1,9 +1,8 @@
 package server
 
 import (
+	"sync"
 	"time"
-
-	"github.com/sirupsen/logrus"
 )
 
 const DefaultCheckInterval = 300
@@ -18,7 +17,7 @@ func NewCheckBrokenConnections(realm IRealm, opts Options, onClose func(client I
 		opts:    opts,
 		onClose: onClose,
 		log:     createLogger("checkBrokenConnections", opts),
-		close:   make(chan bool, 1),
+		close:   make(chan struct{}),
 	}
 }
 
@@ -27,9 +26,10 @@ type CheckBrokenConnections struct {
 	realm   IRealm
 	opts    Options
 	onClose func(IClient)
-	ticker  *time.Ticker
-	log     *logrus.Entry
-	close   chan bool
+	log     Logger
+	// close is closed once by Stop
+	close     chan struct{}
+	closeOnce sync.Once
 }
 
 func (b *CheckBrokenConnections) checkConnections() {
@@ -66,27 +66,25 @@ func (b *CheckBrokenConnections) checkConnections() {
 	}
 }
 
-// Stop close the connection checker
+// Stop close the connection checker, calling it again is a no-op
 func (b *CheckBrokenConnections) Stop() {
-	if b.ticker == nil {
-		return
-	}
-	b.close <- true
+	b.closeOnce.Do(func() {
+		close(b.close)
+	})
 }
 
 // Start initialize the connection checker
 func (b *CheckBrokenConnections) Start() {
 
-	b.ticker = time.NewTicker(DefaultCheckInterval * time.Millisecond)
+	ticker := time.NewTicker(DefaultCheckInterval * time.Millisecond)
 
 	go func() {
+		defer ticker.Stop()
 		for {
 			select {
 			case <-b.close:
-				b.ticker.Stop()
-				b.ticker = nil
 				return
-			case <-b.ticker.C:
+			case <-ticker.C:
 				b.checkConnections()
 			}
 		}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckBrokenConnectionsStopTwice(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	b := NewCheckBrokenConnections(NewRealm(), opts, nil)
+	b.Start()
+
+	stopped := make(chan bool)
+	go func() {
+		wg := sync.WaitGroup{}
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b.Stop()
+			}()
+		}
+		wg.Wait()
+		b.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second * 2):
+		t.Fatal("Stop blocked")
+	}
+}
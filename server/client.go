@@ -1,24 +1,39 @@
 package server
 
-import "github.com/gorilla/websocket"
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// MaxClientPeers max number of peers tracked per client, the least recently
+// contacted one is dropped beyond it
+const MaxClientPeers = 256
 
 // IClient client interface
 type IClient interface {
 	GetID() string
 	GetToken() string
-	GetSocket() *websocket.Conn
-	SetSocket(socket *websocket.Conn)
+	GetSocket() *Conn
+	SetSocket(socket *Conn)
 	GetLastPing() int64
 	SetLastPing(lastPing int64)
 	Send(data []byte) error
+	AddPeer(peerID string)
+	RemovePeer(peerID string)
+	GetPeers() []string
 }
 
 // Client implementation
 type Client struct {
 	id       string
 	token    string
-	socket   *websocket.Conn
+	socket   *Conn
 	lastPing int64
+	// peers exchanging signaling messages with the client, by last message
+	// time
+	peers map[string]int64
+	mutex sync.Mutex
 }
 
 // NewClient initialize a new client
@@ -26,6 +41,7 @@ func NewClient(id string, token string) *Client {
 	c := new(Client)
 	c.id = id
 	c.token = token
+	c.peers = map[string]int64{}
 	c.SetLastPing(getTime())
 	return c
 }
@@ -41,26 +57,68 @@ func (c *Client) GetToken() string {
 }
 
 // GetSocket return the web socket server
-func (c *Client) GetSocket() *websocket.Conn {
+func (c *Client) GetSocket() *Conn {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	return c.socket
 }
 
 // SetSocket set the web socket handler
-func (c *Client) SetSocket(socket *websocket.Conn) {
+func (c *Client) SetSocket(socket *Conn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.socket = socket
 }
 
 // GetLastPing return the last ping timestamp
 func (c *Client) GetLastPing() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	return c.lastPing
 }
 
 // SetLastPing set last ping timestamp
 func (c *Client) SetLastPing(lastPing int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.lastPing = lastPing
 }
 
+// AddPeer track a peer exchanging signaling messages with the client
+func (c *Client) AddPeer(peerID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.peers[peerID]; !ok && len(c.peers) >= MaxClientPeers {
+		oldest := ""
+		for id, last := range c.peers {
+			if oldest == "" || last < c.peers[oldest] {
+				oldest = id
+			}
+		}
+		delete(c.peers, oldest)
+	}
+	c.peers[peerID] = getTime()
+}
+
+// RemovePeer stop tracking a peer, eg. once it left
+func (c *Client) RemovePeer(peerID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.peers, peerID)
+}
+
+// GetPeers return the peers exchanging signaling messages with the client
+func (c *Client) GetPeers() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	peers := []string{}
+	for peerID := range c.peers {
+		peers = append(peers, peerID)
+	}
+	return peers
+}
+
 // Send send data
 func (c *Client) Send(data []byte) error {
-	return c.socket.WriteMessage(websocket.BinaryMessage, data)
+	return c.GetSocket().WriteMessage(websocket.BinaryMessage, data)
 }
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// NewConn wraps a websocket connection
+func NewConn(conn *websocket.Conn) *Conn {
+	return &Conn{
+		Conn: conn,
+	}
+}
+
+// Conn wraps a websocket connection serializing writes, as gorilla/websocket
+// supports only one concurrent writer per connection
+type Conn struct {
+	*websocket.Conn
+	wMutex sync.Mutex
+	// stats counts the messages sent, if set
+	stats *wssStats
+	// onClose is called once the connection is closed, if set
+	onClose   func()
+	closeOnce sync.Once
+	// ip is the client address, see Options.TrustProxy
+	ip string
+	// writeTimeout of each write, if set
+	writeTimeout time.Duration
+}
+
+// RemoteIP return the address of the client, read from the proxy headers if
+// TrustProxy is set
+func (c *Conn) RemoteIP() string {
+	return c.ip
+}
+
+// Close close the connection
+func (c *Conn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return err
+}
+
+// writeMessage write a message holding the connection write lock
+func (c *Conn) writeMessage(messageType int, data []byte) error {
+	c.wMutex.Lock()
+	defer c.wMutex.Unlock()
+	if c.writeTimeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	err := c.Conn.WriteMessage(messageType, data)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		// the client is not reading, the read loop removes it once closed
+		c.Close()
+	}
+	if c.stats != nil && messageType != websocket.CloseMessage {
+		if err != nil {
+			c.stats.addError()
+		} else {
+			c.stats.addMessageOut()
+		}
+	}
+	return err
+}
+
+// WriteMessage write a message to the connection
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	return c.writeMessage(messageType, data)
+}
+
+// WriteJSON write the JSON encoding of v as a text message
+func (c *Conn) WriteJSON(v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(websocket.TextMessage, raw)
+}
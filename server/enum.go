@@ -9,6 +9,32 @@ const (
 	ErrorInvalidWSParameters = "No id, token, or key supplied to websocket server"
 	// ErrorConnectionLimitExceeded Server has reached its concurrent user limit
 	ErrorConnectionLimitExceeded = "Server has reached its concurrent user limit"
+	// ErrorInvalidID Invalid id provided
+	ErrorInvalidID = "Invalid id provided"
+	// ErrorIDGenerationFailed Failed to generate a client id
+	ErrorIDGenerationFailed = "Failed to generate a client id"
+	// ErrorRateLimitExceeded Client has exceeded the message rate limit
+	ErrorRateLimitExceeded = "Client has exceeded the message rate limit"
+	// ErrorIPConnectionLimitExceeded Too many connections from the client address
+	ErrorIPConnectionLimitExceeded = "Too many connections from this address"
+	// ErrorTypeInvalidWSParameters type of the ErrorInvalidWSParameters error
+	ErrorTypeInvalidWSParameters = "invalid-ws-parameters"
+	// ErrorTypeInvalidKey type of the ErrorInvalidKey error
+	ErrorTypeInvalidKey = "invalid-key"
+	// ErrorTypeInvalidToken type of the ErrorInvalidToken error
+	ErrorTypeInvalidToken = "invalid-token"
+	// ErrorTypeInvalidID type of the ErrorInvalidID error
+	ErrorTypeInvalidID = "invalid-id"
+	// ErrorTypeConnectionLimit type of the ErrorConnectionLimitExceeded error
+	ErrorTypeConnectionLimit = "connection-limit"
+	// ErrorTypeIPConnectionLimit type of the ErrorIPConnectionLimitExceeded error
+	ErrorTypeIPConnectionLimit = "ip-connection-limit"
+	// ErrorTypeRateLimit type of the ErrorRateLimitExceeded error
+	ErrorTypeRateLimit = "rate-limit"
+	// ErrorTypeServerError type of the internal errors, eg. ErrorIDGenerationFailed
+	ErrorTypeServerError = "server-error"
+	// ErrorTypeUnauthorized type of the errors returned by the AuthHandler
+	ErrorTypeUnauthorized = "unauthorized"
 	// MessageTypeOpen OPEN
 	MessageTypeOpen = "OPEN"
 	// MessageTypeLeave LEAVE
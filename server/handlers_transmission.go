@@ -26,12 +26,34 @@ func NewTransmissionHandler(realm IRealm, opts Options) func(client IClient, mes
 
 		destinationClient := realm.GetClientByID(dstID)
 
+		if dstID != "" && mtype != MessageTypeLeave && mtype != MessageTypeExpire {
+			// queued messages are delivered on behalf of the source
+			if client != nil && client.GetID() == srcID {
+				client.AddPeer(dstID)
+			}
+			if destinationClient != nil {
+				destinationClient.AddPeer(srcID)
+			}
+		} else if dstID != "" && mtype == MessageTypeLeave {
+			// the peers closed their connection
+			if client != nil && client.GetID() == srcID {
+				client.RemovePeer(dstID)
+			}
+			if destinationClient != nil {
+				destinationClient.RemovePeer(srcID)
+			}
+		}
+
 		// User is connected!
 		if destinationClient != nil {
 			socket := destinationClient.GetSocket()
+			remoteRealm, isRemoteRealm := realm.(IRemoteRealm)
 			var err error
 			if socket != nil {
 				err = socket.WriteJSON(message)
+			} else if isRemoteRealm {
+				// connected to another server instance
+				err = remoteRealm.Deliver(message)
 			} else {
 				err = errors.New("Peer dead")
 			}
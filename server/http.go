@@ -5,12 +5,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"regexp"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/muka/peerjs-go/models"
+	"github.com/muka/peerjs-go/util"
 	"github.com/rs/cors"
-	"github.com/sirupsen/logrus"
 )
 
 // NewOptions create default options
@@ -26,6 +27,10 @@ func NewOptions() Options {
 		ConcurrentLimit: 5000,
 		AllowDiscovery:  false,
 		CleanupOutMsgs:  1000,
+		MaxMessageSize:  DefaultMaxMessageSize,
+		TurnTTL:         DefaultTurnTTL,
+		IDPattern:       DefaultIDPattern,
+		WriteTimeout:    DefaultWriteTimeout,
 	}
 }
 
@@ -41,19 +46,92 @@ type Options struct {
 	ConcurrentLimit int
 	AllowDiscovery  bool
 	CleanupOutMsgs  int
+	// AllowedOrigins origins allowed to open a websocket connection, "*" allows any origin.
+	// If empty, any origin is allowed.
+	AllowedOrigins []string
+	// ReadBufferSize websocket read buffer size in bytes, zero uses the default size
+	ReadBufferSize int
+	// WriteBufferSize websocket write buffer size in bytes, zero uses the default size
+	WriteBufferSize int
+	// MessageRateLimit max messages per second accepted from a client, zero disables the limit
+	MessageRateLimit int
+	// MessageBurst max messages accepted in a burst, defaults to MessageRateLimit
+	MessageBurst int
+	// MaxMessageSize max size in bytes of a message received from a client, larger
+	// messages close the connection. Defaults to 64KB
+	MaxMessageSize int64
+	// EnableMetrics expose the websocket server stats in the Prometheus format at /metrics
+	EnableMetrics bool
+	// Logger used in place of the default logrus logger, LogLevel is ignored when set
+	Logger Logger
+	// TurnSecret shared secret of the TURN server, used to generate time-limited
+	// credentials at /{key}/turn?id={id}. The endpoint is enabled if TurnSecret
+	// and TurnURLs are set
+	TurnSecret string
+	// TurnURLs TURN servers urls returned with the credentials
+	TurnURLs []string
+	// TurnTTL validity in seconds of the TURN credentials. Defaults to 86400
+	TurnTTL int64
+	// AllowServerGeneratedIDs accept websocket connections without id or token,
+	// assigning them a random value sent back in the OPEN payload
+	AllowServerGeneratedIDs bool
+	// IDPattern regular expression client ids must match, empty disables the
+	// check. Defaults to DefaultIDPattern
+	IDPattern string
+	// CORSOrigins origins allowed to call the HTTP API from a browser, "*"
+	// allows any origin. If empty, any origin is allowed
+	CORSOrigins []string
+	// AuthHandler authenticates the websocket connections in place of the Key
+	// check, a non nil error rejects the connection with the error message
+	AuthHandler func(id, token, key string, r *http.Request) error
+	// TokenValidator validates the token of a new client, eg. checking a
+	// signature, rejecting it if invalid. A client connecting with the id of a
+	// registered one must present the same token or receives ID-TAKEN
+	TokenValidator func(id, token string) (bool, error)
+	// TokenGenerator mints the token of the clients connecting without one,
+	// see AllowServerGeneratedIDs. Defaults to a random token
+	TokenGenerator func(id string) (string, error)
+	// MaxConnectionsPerIP max websocket connections open from the same address,
+	// zero disables the limit
+	MaxConnectionsPerIP int
+	// TrustProxy read the client address from the X-Forwarded-For or
+	// X-Real-IP headers, set it only behind a proxy overwriting them
+	TrustProxy bool
+	// TrustedProxies addresses or CIDR networks of the proxies skipped
+	// reading X-Forwarded-For from the right, if set the headers are read
+	// only from them. The rightmost address is used if unset
+	TrustedProxies []string
+	// DisableLeaveOnDisconnect do not send a LEAVE message to the peers a
+	// client exchanged signaling messages with when it disconnects or
+	// expires, which closes their connections to it
+	DisableLeaveOnDisconnect bool
+	// Subprotocols websocket subprotocols supported by the server in order of
+	// preference, the first one requested by a client is selected. Clients
+	// requesting other subprotocols are accepted without one
+	Subprotocols []string
+	// DiscoveryLimit max number of ids returned by the peers discovery, zero
+	// disables the limit
+	DiscoveryLimit int
+	// DiscoveryFilter omits from the peers discovery the ids it returns false
+	// for, if set
+	DiscoveryFilter func(id string) bool
+	// WriteTimeout ms after which a write to a websocket client fails, closing
+	// its connection. Defaults to 5000
+	WriteTimeout int64
 }
 
 // HTTPServer peer server
 type HTTPServer struct {
 	opts           Options
 	realm          IRealm
-	log            *logrus.Entry
+	log            Logger
 	messageHandler *MessageHandler
 	router         *mux.Router
 	http           *http.Server
 	handlers       []func(http.HandlerFunc) http.HandlerFunc
 	auth           *Auth
 	wss            *WebSocketServer
+	idPattern      *regexp.Regexp
 }
 
 // NewHTTPServer init a server
@@ -61,15 +139,17 @@ func NewHTTPServer(realm IRealm, auth *Auth, wss *WebSocketServer, opts Options)
 
 	r := mux.NewRouter()
 
+	log := createLogger("http", opts)
 	s := &HTTPServer{
 		opts:           opts,
 		realm:          realm,
-		log:            createLogger("http", opts),
+		log:            log,
 		router:         r,
 		handlers:       []func(http.HandlerFunc) http.HandlerFunc{},
 		messageHandler: NewMessageHandler(realm, nil, opts),
 		auth:           auth,
 		wss:            wss,
+		idPattern:      compileIDPattern(opts, log),
 	}
 
 	return s
@@ -127,7 +207,7 @@ func (h *HTTPServer) peersHandler() http.HandlerFunc {
 		}
 
 		rw.Header().Add("content-type", "application/json")
-		raw, err := json.Marshal(h.realm.GetClientsIds())
+		raw, err := json.Marshal(h.discoverPeers())
 		if err != nil {
 			h.log.Warnf("/peers: Marshal error %s", err)
 			rw.WriteHeader(http.StatusInternalServerError)
@@ -138,10 +218,27 @@ func (h *HTTPServer) peersHandler() http.HandlerFunc {
 	}
 }
 
+// discoverPeers return the client ids listed by the peers discovery, filtered
+// and capped by the DiscoveryFilter and DiscoveryLimit options
+func (h *HTTPServer) discoverPeers() []string {
+	ids := []string{}
+	for _, id := range h.realm.GetClientsIds() {
+		if h.opts.DiscoveryLimit > 0 && len(ids) >= h.opts.DiscoveryLimit {
+			break
+		}
+		if h.opts.DiscoveryFilter != nil && !h.opts.DiscoveryFilter(id) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 func (h *HTTPServer) registerHandlers() error {
 
-	baseRoute := h.router.PathPrefix(h.opts.Path).Subrouter()
-	h.log.Debugf("Path prefix: %s", h.opts.Path)
+	prefix := util.NormalizePath(h.opts.Path)
+	baseRoute := h.router.PathPrefix(prefix).Subrouter()
+	h.log.Debugf("Path prefix: %s", prefix)
 
 	err := baseRoute.
 		HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
@@ -160,8 +257,14 @@ func (h *HTTPServer) registerHandlers() error {
 	// public API
 	err = baseRoute.
 		HandleFunc("/{key}/id", func(rw http.ResponseWriter, r *http.Request) {
+			id, err := generateClientID(h.realm, h.idPattern)
+			if err != nil {
+				h.log.Warnf("/id: %s", err)
+				http.Error(rw, err.Error(), http.StatusInternalServerError)
+				return
+			}
 			rw.Header().Add("content-type", "text/html")
-			rw.Write([]byte(h.realm.GenerateClientID()))
+			rw.Write([]byte(id))
 		}).
 		Methods("GET").
 		GetError()
@@ -169,6 +272,16 @@ func (h *HTTPServer) registerHandlers() error {
 		return err
 	}
 
+	if h.opts.TurnSecret != "" && len(h.opts.TurnURLs) > 0 {
+		err = baseRoute.
+			Path("/{key}/turn").
+			Handler(h.turnHandler()).
+			Methods("GET").GetError()
+		if err != nil {
+			return err
+		}
+	}
+
 	// public API
 	err = baseRoute.
 		Path("/{key}/peers").
@@ -178,6 +291,16 @@ func (h *HTTPServer) registerHandlers() error {
 		return err
 	}
 
+	if h.opts.EnableMetrics {
+		err = baseRoute.
+			Path("/metrics").
+			Handler(MetricsHandler(h.wss)).
+			Methods("GET").GetError()
+		if err != nil {
+			return err
+		}
+	}
+
 	// handle WS route
 	err = baseRoute.
 		Path("/peerjs").
@@ -228,20 +351,38 @@ func (h *HTTPServer) StartTLS(certFile, keyFile string) error {
 	return h.http.ListenAndServeTLS(certFile, keyFile)
 }
 
-// Stop stops the HTTP server
+// Stop stops the HTTP server, closing the websocket connections
 func (h *HTTPServer) Stop() error {
+	if h.wss != nil {
+		err := h.wss.Close()
+		if err != nil {
+			h.log.Warnf("Failed to close websocket server: %s", err)
+		}
+	}
 	return h.http.Close()
 }
 
+// corsHandler adds the CORS headers for the CORSOrigins option and answers
+// the preflight requests
+func corsHandler(opts Options, handler http.Handler) http.Handler {
+	origins := opts.CORSOrigins
+	if len(origins) == 0 {
+		origins = []string{"*"}
+	}
+	return cors.New(cors.Options{
+		AllowedOrigins:   origins,
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodHead},
+		AllowedHeaders:   []string{"Origin", "Accept", "Content-Type", "X-Requested-With"},
+		AllowCredentials: true,
+	}).Handler(handler)
+}
+
 func newHTTPServer(h *HTTPServer) (*http.Server, error) {
 	err := h.registerHandlers()
 	if err != nil {
 		return nil, err
 	}
-	handler := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowCredentials: true,
-	}).Handler(h.router)
+	handler := corsHandler(h.opts, h.router)
 	return &http.Server{
 		Addr:           fmt.Sprintf("%s:%d", h.opts.Host, h.opts.Port),
 		Handler:        handler,
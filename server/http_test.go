@@ -2,9 +2,16 @@ package server
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -114,3 +121,202 @@ func TestHTTPServerExchange(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
 }
+
+func TestHTTPServerTurnCredentials(t *testing.T) {
+	opts := NewOptions()
+	opts.Port = 64666
+	opts.Host = "localhost"
+	opts.TurnSecret = "turnsecret"
+	opts.TurnURLs = []string{"turn:turn.example.com:3478"}
+	opts.TurnTTL = 3600
+
+	getURL := func(path string) string {
+		return fmt.Sprintf("http://%s:%d%s", opts.Host, opts.Port, path)
+	}
+
+	realm := NewRealm()
+	srv := NewHTTPServer(realm, NewAuth(realm, opts), nil, opts)
+
+	go srv.Start()
+	defer srv.Stop()
+	// wait for server to start
+	<-time.After(time.Millisecond * 200)
+
+	resp, err := http.Get(getURL("/" + opts.Key + "/turn?id=myid"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	defer resp.Body.Close()
+
+	credentials := TurnCredentials{}
+	err = json.NewDecoder(resp.Body).Decode(&credentials)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3600), credentials.TTL)
+	assert.Equal(t, opts.TurnURLs, credentials.URLs)
+
+	parts := strings.SplitN(credentials.Username, ":", 2)
+	assert.Len(t, parts, 2)
+	assert.Equal(t, "myid", parts[1])
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Now().Unix()+3600, expiry, 5)
+
+	mac := hmac.New(sha1.New, []byte(opts.TurnSecret))
+	mac.Write([]byte(credentials.Username))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(mac.Sum(nil)), credentials.Credential)
+
+	resp, err = http.Get(getURL("/invalid/turn?id=myid"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = http.Get(getURL("/" + opts.Key + "/turn"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestHTTPServerTurnDisabled(t *testing.T) {
+	opts := NewOptions()
+	opts.Port = 64666
+	opts.Host = "localhost"
+
+	realm := NewRealm()
+	srv := NewHTTPServer(realm, NewAuth(realm, opts), nil, opts)
+
+	go srv.Start()
+	defer srv.Stop()
+	// wait for server to start
+	<-time.After(time.Millisecond * 200)
+
+	resp, err := http.Get(fmt.Sprintf("http://%s:%d/%s/turn?id=myid", opts.Host, opts.Port, opts.Key))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHTTPServerGetIDPattern(t *testing.T) {
+	opts := NewOptions()
+	opts.Port = 64666
+	opts.Host = "localhost"
+	opts.IDPattern = "^[0-9]+$"
+
+	generator := ClientIDGenerator
+	defer func() {
+		ClientIDGenerator = generator
+	}()
+	ids := []string{"not-a-number", "12345"}
+	ClientIDGenerator = func() string {
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+
+	realm := NewRealm()
+	srv := NewHTTPServer(realm, NewAuth(realm, opts), nil, opts)
+
+	go srv.Start()
+	defer srv.Stop()
+	// wait for server to start
+	<-time.After(time.Millisecond * 200)
+
+	resp, err := http.Get(fmt.Sprintf("http://%s:%d/%s/id", opts.Host, opts.Port, opts.Key))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	defer resp.Body.Close()
+	id, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "12345", string(id))
+}
+
+func TestHTTPServerCORS(t *testing.T) {
+	opts := NewOptions()
+	opts.CORSOrigins = []string{"https://app.example.com"}
+
+	realm := NewRealm()
+	srv := NewHTTPServer(realm, NewAuth(realm, opts), nil, opts)
+	httpSrv, err := newHTTPServer(srv)
+	assert.NoError(t, err)
+
+	serve := func(method, path, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+		}
+		rec := httptest.NewRecorder()
+		httpSrv.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// simple request
+	rec := serve(http.MethodGet, "/"+opts.Key+"/id", "https://app.example.com")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+
+	// preflight
+	rec = serve(http.MethodOptions, "/"+opts.Key+"/myid/token/offer", "https://app.example.com")
+	assert.Less(t, rec.Code, 300)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, http.MethodPost, rec.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type", rec.Header().Get("Access-Control-Allow-Headers"))
+
+	// other origins get no CORS headers
+	rec = serve(http.MethodGet, "/"+opts.Key+"/id", "https://evil.example.com")
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+	rec = serve(http.MethodOptions, "/"+opts.Key+"/myid/token/offer", "https://evil.example.com")
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestHTTPServerCORSDefault(t *testing.T) {
+	opts := NewOptions()
+	realm := NewRealm()
+	srv := NewHTTPServer(realm, NewAuth(realm, opts), nil, opts)
+	httpSrv, err := newHTTPServer(srv)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/"+opts.Key+"/id", nil)
+	req.Header.Set("Origin", "https://any.example.com")
+	rec := httptest.NewRecorder()
+	httpSrv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestHTTPServerDiscoveryLimitAndFilter(t *testing.T) {
+	opts := NewOptions()
+	opts.AllowDiscovery = true
+
+	realm := NewRealm()
+	for _, id := range []string{"public1", "public2", "public3", "private1", "private2"} {
+		realm.SetClient(NewClient(id, "token"), id)
+	}
+
+	listPeers := func(opts Options) []string {
+		srv := NewHTTPServer(realm, NewAuth(realm, opts), nil, opts)
+		httpSrv, err := newHTTPServer(srv)
+		assert.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/"+opts.Key+"/peers", nil)
+		rec := httptest.NewRecorder()
+		httpSrv.Handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		peers := []string{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &peers))
+		return peers
+	}
+
+	assert.Len(t, listPeers(opts), 5)
+
+	opts.DiscoveryLimit = 2
+	assert.Len(t, listPeers(opts), 2)
+
+	opts.DiscoveryLimit = 0
+	opts.DiscoveryFilter = func(id string) bool {
+		return !strings.HasPrefix(id, "private")
+	}
+	assert.ElementsMatch(t, []string{"public1", "public2", "public3"}, listPeers(opts))
+
+	// the limit counts the ids passing the filter
+	opts.DiscoveryLimit = 2
+	peers := listPeers(opts)
+	assert.Len(t, peers, 2)
+	for _, id := range peers {
+		assert.True(t, strings.HasPrefix(id, "public"))
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"time"
 
 	"github.com/muka/peerjs-go/models"
-	"github.com/sirupsen/logrus"
 )
 
 // IMessagesExpire MessagesExpire interface
@@ -30,7 +29,7 @@ type MessagesExpire struct {
 	opts           Options
 	messageHandler IMessageHandler
 	ticker         *time.Ticker
-	log            *logrus.Entry
+	log            Logger
 	close          chan bool
 }
 
@@ -30,6 +30,8 @@ type MessageQueue struct {
 
 // GetLastReadAt return last message read time
 func (mq *MessageQueue) GetLastReadAt() int64 {
+	mq.mMutex.Lock()
+	defer mq.mMutex.Unlock()
 	return mq.lastReadAt
 }
 
@@ -42,18 +44,20 @@ func (mq *MessageQueue) AddMessage(message models.IMessage) {
 
 // ReadMessage read last message
 func (mq *MessageQueue) ReadMessage() models.IMessage {
-	if len(mq.messages) > 0 {
-		mq.mMutex.Lock()
-		defer mq.mMutex.Unlock()
-		mq.lastReadAt = getTime()
-		msg := mq.messages[0]
-		mq.messages = mq.messages[1:]
-		return msg
+	mq.mMutex.Lock()
+	defer mq.mMutex.Unlock()
+	if len(mq.messages) == 0 {
+		return nil
 	}
-	return nil
+	mq.lastReadAt = getTime()
+	msg := mq.messages[0]
+	mq.messages = mq.messages[1:]
+	return msg
 }
 
 // GetMessages return all queued messages
 func (mq *MessageQueue) GetMessages() []models.IMessage {
-	return mq.messages
+	mq.mMutex.Lock()
+	defer mq.mMutex.Unlock()
+	return append([]models.IMessage{}, mq.messages...)
 }
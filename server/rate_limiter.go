@@ -0,0 +1,61 @@
+package server
+
+import "time"
+
+// MaxRateLimitedMessages number of dropped messages within a second after
+// which a client is disconnected
+const MaxRateLimitedMessages = 100
+
+// newRateLimiter creates a token bucket allowing rate messages per second with
+// bursts up to burst messages
+func newRateLimiter(rate int, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = rate
+	}
+	now := time.Now()
+	return &rateLimiter{
+		rate:        float64(rate),
+		burst:       float64(burst),
+		tokens:      float64(burst),
+		last:        now,
+		windowStart: now,
+	}
+}
+
+// rateLimiter token bucket limiting the messages sent by a client. It is not
+// safe for concurrent use, each client read loop owns its own limiter
+type rateLimiter struct {
+	rate        float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+	dropped     int
+	windowStart time.Time
+}
+
+// Allow consume a token, returns false if the message should be dropped
+func (l *rateLimiter) Allow() bool {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true
+	}
+
+	if now.Sub(l.windowStart) > time.Second {
+		l.windowStart = now
+		l.dropped = 0
+	}
+	l.dropped++
+	return false
+}
+
+// Abused returns true if too many messages have been dropped within a second
+func (l *rateLimiter) Abused() bool {
+	return l.dropped > MaxRateLimitedMessages
+}
@@ -46,6 +46,8 @@ type Realm struct {
 
 // GetClientsIds return the list of client id
 func (r *Realm) GetClientsIds() []string {
+	r.cMutex.Lock()
+	defer r.cMutex.Unlock()
 	keys := []string{}
 	for key := range r.clients {
 		keys = append(keys, key)
@@ -55,6 +57,8 @@ func (r *Realm) GetClientsIds() []string {
 
 // GetClientByID return client by id
 func (r *Realm) GetClientByID(clientID string) IClient {
+	r.cMutex.Lock()
+	defer r.cMutex.Unlock()
 	c, ok := r.clients[clientID]
 	if !ok {
 		return nil
@@ -64,6 +68,8 @@ func (r *Realm) GetClientByID(clientID string) IClient {
 
 // GetClientsIdsWithQueue retur clients with queue
 func (r *Realm) GetClientsIdsWithQueue() []string {
+	r.mMutex.Lock()
+	defer r.mMutex.Unlock()
 	keys := []string{}
 	for key := range r.messageQueues {
 		keys = append(keys, key)
@@ -80,18 +86,19 @@ func (r *Realm) SetClient(client IClient, id string) {
 
 // RemoveClientByID remove a client by id
 func (r *Realm) RemoveClientByID(id string) bool {
-	client := r.GetClientByID(id)
-	if client == nil {
-		return false
-	}
 	r.cMutex.Lock()
 	defer r.cMutex.Unlock()
+	if _, ok := r.clients[id]; !ok {
+		return false
+	}
 	delete(r.clients, id)
 	return true
 }
 
 // GetMessageQueueByID get message by queue id
 func (r *Realm) GetMessageQueueByID(id string) IMessageQueue {
+	r.mMutex.Lock()
+	defer r.mMutex.Unlock()
 	m, ok := r.messageQueues[id]
 	if !ok {
 		return nil
@@ -101,16 +108,15 @@ func (r *Realm) GetMessageQueueByID(id string) IMessageQueue {
 
 // AddMessageToQueue add message to queue
 func (r *Realm) AddMessageToQueue(id string, message models.IMessage) {
-	if r.GetMessageQueueByID(id) == nil {
-		r.mMutex.Lock()
-		r.messageQueues[id] = NewMessageQueue()
-		r.mMutex.Unlock()
+	r.mMutex.Lock()
+	m, ok := r.messageQueues[id]
+	if !ok {
+		m = NewMessageQueue()
+		r.messageQueues[id] = m
 	}
+	r.mMutex.Unlock()
 
-	m := r.GetMessageQueueByID(id)
-	if m != nil {
-		m.AddMessage(message)
-	}
+	m.AddMessage(message)
 }
 
 // ClearMessageQueue clear message queue
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/muka/peerjs-go/models"
+)
+
+// DefaultRedisRealmTTL is the default expiration of the clients registered in Redis
+const DefaultRedisRealmTTL = time.Minute
+
+// RedisClient is the subset of Redis commands used by RedisRealm. Wrap your
+// Redis driver of choice (eg. go-redis) to implement it.
+type RedisClient interface {
+	// Set stores value at key expiring after ttl (SET key value PX ttl)
+	Set(key string, value string, ttl time.Duration) error
+	// Get returns the value at key, ok is false if the key does not exist
+	Get(key string) (value string, ok bool, err error)
+	// Del removes key
+	Del(key string) error
+	// Keys returns the keys matching pattern, preferably iterating with SCAN
+	Keys(pattern string) ([]string, error)
+	// Publish sends a message to channel
+	Publish(channel string, message []byte) error
+	// Subscribe registers handler for the messages sent to channel. The
+	// returned function cancels the subscription
+	Subscribe(channel string, handler func(message []byte)) (unsubscribe func(), err error)
+}
+
+// IRemoteRealm is implemented by realms shared between server instances. A
+// client returned by GetClientByID without a socket is connected to another
+// instance and messages to it are sent with Deliver
+type IRemoteRealm interface {
+	IRealm
+	Deliver(message models.IMessage) error
+}
+
+// NewRedisRealm creates a realm sharing clients between server instances with
+// Redis. Clients connected to this instance are kept locally and their ID and
+// token are stored in Redis expiring after ttl, call Start to refresh them
+// periodically
+func NewRedisRealm(client RedisClient, prefix string, ttl time.Duration, opts Options) *RedisRealm {
+	if ttl <= 0 {
+		ttl = DefaultRedisRealmTTL
+	}
+	return &RedisRealm{
+		Realm:         NewRealm(),
+		redis:         client,
+		prefix:        prefix,
+		ttl:           ttl,
+		log:           createLogger("redis-realm", opts),
+		subscriptions: map[string]func(){},
+	}
+}
+
+// RedisRealm implements IRemoteRealm storing clients in Redis
+type RedisRealm struct {
+	*Realm
+	redis         RedisClient
+	prefix        string
+	ttl           time.Duration
+	log           Logger
+	subscriptions map[string]func()
+	sMutex        sync.Mutex
+	// stop is closed to stop the refresh, nil if not started. stopped is
+	// closed once the refresh go routine exits
+	stop    chan struct{}
+	stopped chan struct{}
+	tMutex  sync.Mutex
+}
+
+func (r *RedisRealm) clientKey(id string) string {
+	return r.prefix + "client:" + id
+}
+
+func (r *RedisRealm) messagesChannel(id string) string {
+	return r.prefix + "messages:" + id
+}
+
+// GetClientsIds return the list of client id connected to any instance
+func (r *RedisRealm) GetClientsIds() []string {
+	keys, err := r.redis.Keys(r.clientKey("*"))
+	if err != nil {
+		r.log.Warnf("Failed to list clients: %s", err)
+		return r.Realm.GetClientsIds()
+	}
+	ids := []string{}
+	for _, key := range keys {
+		ids = append(ids, strings.TrimPrefix(key, r.clientKey("")))
+	}
+	return ids
+}
+
+// GetClientByID return a local client or a client connected to another
+// instance, which has no socket
+func (r *RedisRealm) GetClientByID(clientID string) IClient {
+	if client := r.Realm.GetClientByID(clientID); client != nil {
+		return client
+	}
+	token, ok, err := r.redis.Get(r.clientKey(clientID))
+	if err != nil {
+		r.log.Warnf("Failed to get client %s: %s", clientID, err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	return NewClient(clientID, token)
+}
+
+// SetClient set a local client, subscribing to the messages sent by other instances
+func (r *RedisRealm) SetClient(client IClient, id string) {
+	r.Realm.SetClient(client, id)
+
+	err := r.redis.Set(r.clientKey(id), client.GetToken(), r.ttl)
+	if err != nil {
+		r.log.Warnf("Failed to store client %s: %s", id, err)
+	}
+
+	unsubscribe, err := r.redis.Subscribe(r.messagesChannel(id), func(raw []byte) {
+		r.onMessage(id, raw)
+	})
+	if err != nil {
+		r.log.Warnf("Failed to subscribe messages for %s: %s", id, err)
+		return
+	}
+
+	r.sMutex.Lock()
+	defer r.sMutex.Unlock()
+	if prev, ok := r.subscriptions[id]; ok {
+		prev()
+	}
+	r.subscriptions[id] = unsubscribe
+}
+
+// RemoveClientByID remove a local client by id. Clients connected to other
+// instances are left untouched
+func (r *RedisRealm) RemoveClientByID(id string) bool {
+	if !r.Realm.RemoveClientByID(id) {
+		return false
+	}
+
+	r.sMutex.Lock()
+	if unsubscribe, ok := r.subscriptions[id]; ok {
+		unsubscribe()
+		delete(r.subscriptions, id)
+	}
+	r.sMutex.Unlock()
+
+	err := r.redis.Del(r.clientKey(id))
+	if err != nil {
+		r.log.Warnf("Failed to remove client %s: %s", id, err)
+	}
+	return true
+}
+
+// Deliver send a message to a client connected to another instance
+func (r *RedisRealm) Deliver(message models.IMessage) error {
+	raw, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return r.redis.Publish(r.messagesChannel(message.GetDst()), raw)
+}
+
+// onMessage write a message delivered by another instance to a local client
+func (r *RedisRealm) onMessage(id string, raw []byte) {
+	client := r.Realm.GetClientByID(id)
+	if client == nil {
+		return
+	}
+	socket := client.GetSocket()
+	if socket == nil {
+		// not connected yet, wait for the client to connect
+		message := models.Message{}
+		if err := json.Unmarshal(raw, &message); err != nil {
+			r.log.Warnf("Failed to decode message for %s: %s", id, err)
+			return
+		}
+		r.AddMessageToQueue(id, message)
+		return
+	}
+	err := socket.WriteMessage(websocket.TextMessage, raw)
+	if err != nil {
+		r.log.Warnf("Failed to deliver message to %s: %s", id, err)
+	}
+}
+
+// refresh extends the expiration of the local clients
+func (r *RedisRealm) refresh() {
+	for _, id := range r.Realm.GetClientsIds() {
+		client := r.Realm.GetClientByID(id)
+		if client == nil {
+			continue
+		}
+		err := r.redis.Set(r.clientKey(id), client.GetToken(), r.ttl)
+		if err != nil {
+			r.log.Warnf("Failed to refresh client %s: %s", id, err)
+		}
+	}
+}
+
+// Start periodically refresh the expiration of the local clients. Starting a
+// started realm is a no-op
+func (r *RedisRealm) Start() {
+	r.tMutex.Lock()
+	defer r.tMutex.Unlock()
+	if r.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	r.stop = stop
+	r.stopped = stopped
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(r.ttl / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				r.refresh()
+			}
+		}
+	}()
+}
+
+// Stop the expiration refresh, waiting for a running refresh to complete.
+// Stopping a stopped realm is a no-op
+func (r *RedisRealm) Stop() {
+	r.tMutex.Lock()
+	defer r.tMutex.Unlock()
+	if r.stop == nil {
+		return
+	}
+	close(r.stop)
+	<-r.stopped
+	r.stop = nil
+	r.stopped = nil
+}
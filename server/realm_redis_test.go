@@ -0,0 +1,240 @@
+package server
+
+import (
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/muka/peerjs-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// testRedis is an in-memory RedisClient, ignoring expiration
+type testRedis struct {
+	mutex       sync.Mutex
+	values      map[string]string
+	ttls        map[string]time.Duration
+	subscribers map[string]map[int]func([]byte)
+	lastSubID   int
+}
+
+func newTestRedis() *testRedis {
+	return &testRedis{
+		values:      map[string]string{},
+		ttls:        map[string]time.Duration{},
+		subscribers: map[string]map[int]func([]byte){},
+	}
+}
+
+func (r *testRedis) Set(key string, value string, ttl time.Duration) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.values[key] = value
+	r.ttls[key] = ttl
+	return nil
+}
+
+func (r *testRedis) Get(key string) (string, bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	value, ok := r.values[key]
+	return value, ok, nil
+}
+
+func (r *testRedis) Del(key string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.values, key)
+	return nil
+}
+
+func (r *testRedis) Keys(pattern string) ([]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	keys := []string{}
+	for key := range r.values {
+		if ok, _ := path.Match(pattern, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (r *testRedis) Publish(channel string, message []byte) error {
+	r.mutex.Lock()
+	handlers := []func([]byte){}
+	for _, handler := range r.subscribers[channel] {
+		handlers = append(handlers, handler)
+	}
+	r.mutex.Unlock()
+	for _, handler := range handlers {
+		handler(message)
+	}
+	return nil
+}
+
+func (r *testRedis) Subscribe(channel string, handler func([]byte)) (func(), error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.subscribers[channel]; !ok {
+		r.subscribers[channel] = map[int]func([]byte){}
+	}
+	r.lastSubID++
+	id := r.lastSubID
+	r.subscribers[channel][id] = handler
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		delete(r.subscribers[channel], id)
+	}, nil
+}
+
+func TestRedisRealmClients(t *testing.T) {
+	redis := newTestRedis()
+	opts := NewOptions()
+	r1 := NewRedisRealm(redis, "peerjs:", time.Second*10, opts)
+	r2 := NewRedisRealm(redis, "peerjs:", time.Second*10, opts)
+
+	r1.SetClient(NewClient("a", "token-a"), "a")
+	r2.SetClient(NewClient("b", "token-b"), "b")
+	assert.Equal(t, time.Second*10, redis.ttls["peerjs:client:a"])
+
+	assert.ElementsMatch(t, []string{"a", "b"}, r1.GetClientsIds())
+	assert.ElementsMatch(t, []string{"a", "b"}, r2.GetClientsIds())
+
+	// remote client, without a socket
+	remote := r2.GetClientByID("a")
+	assert.NotNil(t, remote)
+	assert.Equal(t, "token-a", remote.GetToken())
+	assert.Nil(t, remote.GetSocket())
+
+	// only the owner removes a client
+	assert.False(t, r2.RemoveClientByID("a"))
+	assert.NotNil(t, r1.GetClientByID("a"))
+	assert.True(t, r1.RemoveClientByID("a"))
+	assert.Nil(t, r2.GetClientByID("a"))
+	assert.Equal(t, []string{"b"}, r1.GetClientsIds())
+}
+
+func TestRedisRealmForwardToRemoteClient(t *testing.T) {
+	redis := newTestRedis()
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	r1 := NewRedisRealm(redis, "peerjs:", 0, opts)
+	r2 := NewRedisRealm(redis, "peerjs:", 0, opts)
+
+	// client a connects to the first instance
+	wss := NewWebSocketServer(r1, opts)
+	defer wss.Close()
+	srv := testServeWSS(wss)
+	defer srv.Close()
+	c := testDialWS(t, srv, opts.Key, "a", "token-a")
+	defer c.Close()
+
+	// client b, on the second instance, sends an offer to a
+	r2.SetClient(NewClient("b", "token-b"), "b")
+	handle := NewTransmissionHandler(r2, opts)
+	handle(r2.GetClientByID("b"), models.Message{
+		Type: MessageTypeOffer,
+		Src:  "b",
+		Dst:  "a",
+	})
+
+	msg := models.Message{}
+	err := c.ReadJSON(&msg)
+	assert.NoError(t, err)
+	assert.Equal(t, MessageTypeOffer, msg.Type)
+	assert.Equal(t, "b", msg.Src)
+	// the remote client is still registered
+	assert.NotNil(t, r2.GetClientByID("a"))
+}
+
+func TestRedisRealmReconnectToOtherInstance(t *testing.T) {
+	redis := newTestRedis()
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	r1 := NewRedisRealm(redis, "peerjs:", 0, opts)
+	r2 := NewRedisRealm(redis, "peerjs:", 0, opts)
+
+	wss1 := NewWebSocketServer(r1, opts)
+	defer wss1.Close()
+	srv1 := testServeWSS(wss1)
+	defer srv1.Close()
+	wss2 := NewWebSocketServer(r2, opts)
+	defer wss2.Close()
+	srv2 := testServeWSS(wss2)
+	defer srv2.Close()
+
+	// client a connects to the first instance, then to the second one
+	c1 := testDialWS(t, srv1, opts.Key, "a", "token-a")
+	defer c1.Close()
+	c2 := testDialWS(t, srv2, opts.Key, "a", "token-a")
+	defer c2.Close()
+
+	// the client is now local to the second instance
+	local := r2.Realm.GetClientByID("a")
+	if !assert.NotNil(t, local) {
+		return
+	}
+	assert.NotNil(t, local.GetSocket())
+
+	r2.SetClient(NewClient("b", "token-b"), "b")
+	handle := NewTransmissionHandler(r2, opts)
+	handle(r2.GetClientByID("b"), models.Message{
+		Type: MessageTypeOffer,
+		Src:  "b",
+		Dst:  "a",
+	})
+
+	msg := models.Message{}
+	err := c2.ReadJSON(&msg)
+	assert.NoError(t, err)
+	assert.Equal(t, MessageTypeOffer, msg.Type)
+	assert.Equal(t, "b", msg.Src)
+}
+
+func TestRedisRealmStartStop(t *testing.T) {
+	redis := newTestRedis()
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	r := NewRedisRealm(redis, "peerjs:", time.Millisecond*20, opts)
+	r.SetClient(NewClient("a", "token-a"), "a")
+
+	stored := func() bool {
+		_, ok, _ := redis.Get("peerjs:client:a")
+		return ok
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Start()
+		}()
+	}
+	wg.Wait()
+
+	// the refresh restores the expired key
+	redis.Del("peerjs:client:a")
+	assert.Eventually(t, stored, time.Second, time.Millisecond*5)
+
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Stop()
+		}()
+	}
+	wg.Wait()
+
+	redis.Del("peerjs:client:a")
+	<-time.After(time.Millisecond * 50)
+	assert.False(t, stored())
+
+	// restart after stop
+	r.Start()
+	defer r.Stop()
+	assert.Eventually(t, stored, time.Second, time.Millisecond*5)
+}
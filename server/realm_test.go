@@ -1,6 +1,7 @@
 package server
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/muka/peerjs-go/models"
@@ -73,3 +74,28 @@ func TestRealmRemove(t *testing.T) {
 
 	assert.Equal(t, len(r.GetClientsIds()), 0)
 }
+
+func TestRealmQueueConcurrent(t *testing.T) {
+	r := NewRealm()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.AddMessageToQueue("1", models.Message{})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if q := r.GetMessageQueueByID("1"); q != nil {
+					q.ReadMessage()
+					q.GetMessages()
+				}
+				r.GetClientsIdsWithQueue()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrClientNotConnected is returned joining a room or sending a message to a
+// client not connected to this server
+var ErrClientNotConnected = errors.New("client not connected")
+
+// JoinRoom add a connected client to room. Clients leave their rooms when they
+// disconnect
+func (wss *WebSocketServer) JoinRoom(clientID, room string) error {
+	wss.cMutex.Lock()
+	defer wss.cMutex.Unlock()
+	if _, ok := wss.clients[clientID]; !ok {
+		return ErrClientNotConnected
+	}
+	members, ok := wss.rooms[room]
+	if !ok {
+		members = map[string]bool{}
+		wss.rooms[room] = members
+	}
+	members[clientID] = true
+	return nil
+}
+
+// LeaveRoom remove a client from room
+func (wss *WebSocketServer) LeaveRoom(clientID, room string) {
+	wss.cMutex.Lock()
+	defer wss.cMutex.Unlock()
+	wss.leaveRoom(clientID, room)
+}
+
+// RoomMembers return the ids of the clients in room
+func (wss *WebSocketServer) RoomMembers(room string) []string {
+	wss.cMutex.Lock()
+	defer wss.cMutex.Unlock()
+	ids := []string{}
+	for clientID := range wss.rooms[room] {
+		ids = append(ids, clientID)
+	}
+	return ids
+}
+
+// SendToRoom send data to the members of room, like Send does to every client
+func (wss *WebSocketServer) SendToRoom(room string, data []byte) {
+	wss.cMutex.Lock()
+	conns := []*Conn{}
+	for clientID := range wss.rooms[room] {
+		if conn, ok := wss.clients[clientID]; ok {
+			conns = append(conns, conn)
+		}
+	}
+	wss.cMutex.Unlock()
+
+	for _, conn := range conns {
+		err := conn.WriteMessage(websocket.BinaryMessage, data)
+		if err != nil {
+			wss.log.Warnf("Write to room %s failed: %s", room, err)
+		}
+	}
+}
+
+// leaveRoom must be called holding cMutex
+func (wss *WebSocketServer) leaveRoom(clientID, room string) {
+	members, ok := wss.rooms[room]
+	if !ok {
+		return
+	}
+	delete(members, clientID)
+	if len(members) == 0 {
+		delete(wss.rooms, room)
+	}
+}
+
+// leaveRooms remove a disconnected client from its rooms, must be called
+// holding cMutex
+func (wss *WebSocketServer) leaveRooms(clientID string) {
+	for room := range wss.rooms {
+		wss.leaveRoom(clientID, room)
+	}
+}
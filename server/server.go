@@ -4,25 +4,24 @@ import (
 	"time"
 
 	"github.com/muka/peerjs-go/emitter"
-	"github.com/sirupsen/logrus"
+	"github.com/muka/peerjs-go/models"
 )
 
 // New creates a new PeerServer
 func New(opts Options) *PeerServer {
+	return NewWithRealm(NewRealm(), opts)
+}
+
+// NewWithRealm creates a new PeerServer storing clients in realm, eg. a
+// RedisRealm shared by multiple server instances
+func NewWithRealm(realm IRealm, opts Options) *PeerServer {
 	s := new(PeerServer)
 	s.Emitter = emitter.NewEmitter()
 	s.log = createLogger("peer", opts)
-	s.realm = NewRealm()
+	s.realm = realm
 	s.auth = NewAuth(s.realm, opts)
 	s.wss = NewWebSocketServer(s.realm, opts)
 	s.http = NewHTTPServer(s.realm, s.auth, s.wss, opts)
-	s.checkBrokenConnections = NewCheckBrokenConnections(
-		s.realm,
-		opts,
-		func(client IClient) {
-			s.Emit("disconnect", client)
-		},
-	)
 	s.messageExpire = NewMessagesExpire(s.realm, opts, s.http.messageHandler)
 	s.initialize()
 	return s
@@ -31,13 +30,12 @@ func New(opts Options) *PeerServer {
 // PeerServer wrap the peer server functionalities
 type PeerServer struct {
 	emitter.Emitter
-	log                    *logrus.Entry
-	http                   *HTTPServer
-	realm                  IRealm
-	auth                   *Auth
-	wss                    *WebSocketServer
-	checkBrokenConnections *CheckBrokenConnections
-	messageExpire          IMessagesExpire
+	log           Logger
+	http          *HTTPServer
+	realm         IRealm
+	auth          *Auth
+	wss           *WebSocketServer
+	messageExpire IMessagesExpire
 }
 
 func (p *PeerServer) initialize() {
@@ -66,6 +64,9 @@ func (p *PeerServer) initialize() {
 
 	p.wss.On("close", func(data interface{}) {
 		client := data.(IClient)
+		if !p.http.opts.DisableLeaveOnDisconnect {
+			p.notifyLeave(client)
+		}
 		p.Emit("disconnect", client)
 	})
 
@@ -75,15 +76,25 @@ func (p *PeerServer) initialize() {
 	})
 
 	p.messageExpire.Start()
-	p.checkBrokenConnections.Start()
+}
+
+// notifyLeave send a LEAVE message to the peers of a disconnected client
+func (p *PeerServer) notifyLeave(client IClient) {
+	for _, peerID := range client.GetPeers() {
+		p.log.Debugf("[%s] Notify leave to %s", client.GetID(), peerID)
+		p.http.messageHandler.Handle(client, models.Message{
+			Type: MessageTypeLeave,
+			Src:  client.GetID(),
+			Dst:  peerID,
+		})
+	}
 }
 
 // Stop stops the peer server
 func (p *PeerServer) Stop() error {
 	p.http.Stop()
 	p.messageExpire.Stop()
-	p.checkBrokenConnections.Stop()
-	p.log.Info("Peer server stopped")
+	p.log.Infof("Peer server stopped")
 	return nil
 }
 
@@ -2,11 +2,15 @@ package server
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/muka/peerjs-go"
+	"github.com/muka/peerjs-go/models"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -98,3 +102,251 @@ func TestPeerServer_ClientPingPong(t *testing.T) {
 	}
 
 }
+
+// testServePeerServer serve a PeerServer with httptest, call the returned
+// function to stop it
+func testServePeerServer(t *testing.T, opts Options) (*httptest.Server, func()) {
+	p := New(opts)
+	err := p.http.registerHandlers()
+	assert.NoError(t, err)
+	srv := httptest.NewServer(p.http.router)
+	return srv, func() {
+		srv.Close()
+		p.wss.Close()
+		p.messageExpire.Stop()
+	}
+}
+
+// testLeaveOnDisconnect exchange an offer between two clients, disconnect the
+// first and return the next message received by the second one
+func testLeaveOnDisconnect(t *testing.T, opts Options, disconnect func(c *websocket.Conn)) (models.Message, error) {
+	srv, stop := testServePeerServer(t, opts)
+	defer stop()
+
+	c1 := testDialWS(t, srv, opts.Key, "peer1", "token")
+	defer c1.Close()
+	c2 := testDialWS(t, srv, opts.Key, "peer2", "token")
+	defer c2.Close()
+
+	// keep the second client alive
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond * 50):
+				c2.WriteJSON(models.Message{Type: MessageTypeHeartbeat})
+			}
+		}
+	}()
+
+	err := c1.WriteJSON(models.Message{
+		Type: MessageTypeOffer,
+		Dst:  "peer2",
+	})
+	assert.NoError(t, err)
+
+	c2.SetReadDeadline(time.Now().Add(time.Second * 2))
+	msg := models.Message{}
+	err = c2.ReadJSON(&msg)
+	assert.NoError(t, err)
+	assert.Equal(t, MessageTypeOffer, msg.Type)
+	assert.Equal(t, "peer1", msg.Src)
+
+	disconnect(c1)
+
+	for {
+		msg = models.Message{}
+		err = c2.ReadJSON(&msg)
+		if err != nil || msg.Type != MessageTypeHeartbeat {
+			return msg, err
+		}
+	}
+}
+
+func TestPeerServer_LeaveOnDisconnect(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	msg, err := testLeaveOnDisconnect(t, opts, func(c *websocket.Conn) {
+		c.Close()
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, MessageTypeLeave, msg.Type)
+	assert.Equal(t, "peer1", msg.Src)
+	assert.Equal(t, "peer2", msg.Dst)
+}
+
+func TestPeerServer_LeaveOnExpire(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	opts.AliveTimeout = 300
+	// the first client stops sending heartbeats
+	msg, err := testLeaveOnDisconnect(t, opts, func(c *websocket.Conn) {})
+	assert.NoError(t, err)
+	assert.Equal(t, MessageTypeLeave, msg.Type)
+	assert.Equal(t, "peer1", msg.Src)
+	assert.Equal(t, "peer2", msg.Dst)
+}
+
+func TestPeerServer_DisableLeaveOnDisconnect(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	opts.DisableLeaveOnDisconnect = true
+	// the read deadline is reached without messages
+	_, err := testLeaveOnDisconnect(t, opts, func(c *websocket.Conn) {
+		c.Close()
+	})
+	assert.Error(t, err)
+}
+
+func TestClientPeers(t *testing.T) {
+	c := NewClient("client", "token")
+	for i := 0; i < MaxClientPeers+10; i++ {
+		c.AddPeer(fmt.Sprintf("peer%d", i))
+	}
+	assert.Len(t, c.GetPeers(), MaxClientPeers)
+
+	c.RemovePeer(c.GetPeers()[0])
+	assert.Len(t, c.GetPeers(), MaxClientPeers-1)
+}
+
+func TestPeerServer_LeavePrunesPeers(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	p := New(opts)
+	err := p.http.registerHandlers()
+	assert.NoError(t, err)
+	srv := httptest.NewServer(p.http.router)
+	defer func() {
+		srv.Close()
+		p.wss.Close()
+		p.messageExpire.Stop()
+	}()
+
+	c1 := testDialWS(t, srv, opts.Key, "peer1", "token")
+	defer c1.Close()
+	c2 := testDialWS(t, srv, opts.Key, "peer2", "token")
+	defer c2.Close()
+	c2.SetReadDeadline(time.Now().Add(time.Second * 2))
+
+	for _, mtype := range []string{MessageTypeOffer, MessageTypeLeave} {
+		err = c1.WriteJSON(models.Message{Type: mtype, Dst: "peer2"})
+		assert.NoError(t, err)
+		msg := models.Message{}
+		err = c2.ReadJSON(&msg)
+		assert.NoError(t, err)
+		assert.Equal(t, mtype, msg.Type)
+
+		if mtype == MessageTypeOffer {
+			assert.Equal(t, []string{"peer2"}, p.realm.GetClientByID("peer1").GetPeers())
+			assert.Equal(t, []string{"peer1"}, p.realm.GetClientByID("peer2").GetPeers())
+		}
+	}
+	assert.Empty(t, p.realm.GetClientByID("peer1").GetPeers())
+	assert.Empty(t, p.realm.GetClientByID("peer2").GetPeers())
+}
+
+func TestPeerServer_QueuedOffer(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	srv, stop := testServePeerServer(t, opts)
+	defer stop()
+
+	c1 := testDialWS(t, srv, opts.Key, "peer1", "token")
+	defer c1.Close()
+
+	// peer2 is not connected yet
+	err := c1.WriteJSON(models.Message{
+		Type: MessageTypeOffer,
+		Dst:  "peer2",
+	})
+	assert.NoError(t, err)
+	<-time.After(time.Millisecond * 100)
+
+	c2 := testDialWS(t, srv, opts.Key, "peer2", "token")
+	defer c2.Close()
+
+	c2.SetReadDeadline(time.Now().Add(time.Second * 2))
+	msg := models.Message{}
+	err = c2.ReadJSON(&msg)
+	assert.NoError(t, err)
+	assert.Equal(t, MessageTypeOffer, msg.Type)
+	assert.Equal(t, "peer1", msg.Src)
+}
+
+func TestPeerServer_ExpiredOffer(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	opts.ExpireTimeout = 100
+	srv, stop := testServePeerServer(t, opts)
+	defer stop()
+
+	c1 := testDialWS(t, srv, opts.Key, "peer1", "token")
+	defer c1.Close()
+
+	err := c1.WriteJSON(models.Message{
+		Type: MessageTypeOffer,
+		Dst:  "peer2",
+	})
+	assert.NoError(t, err)
+
+	c1.SetReadDeadline(time.Now().Add(time.Second * 2))
+	msg := models.Message{}
+	err = c1.ReadJSON(&msg)
+	assert.NoError(t, err)
+	assert.Equal(t, MessageTypeExpire, msg.Type)
+	assert.Equal(t, "peer2", msg.Src)
+	assert.Equal(t, "peer1", msg.Dst)
+}
+
+func TestPeerServer_QueuedMessagesExpire(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	opts.ExpireTimeout = 500
+	p := New(opts)
+	err := p.http.registerHandlers()
+	assert.NoError(t, err)
+	srv := httptest.NewServer(p.http.router)
+	defer func() {
+		srv.Close()
+		p.wss.Close()
+		p.messageExpire.Stop()
+	}()
+
+	c1 := testDialWS(t, srv, opts.Key, "peer1", "token")
+	defer c1.Close()
+
+	// peer2 is not connected, its signaling messages are queued
+	sentAt := time.Now()
+	for _, mtype := range []string{MessageTypeOffer, MessageTypeCandidate, MessageTypeAnswer} {
+		err := c1.WriteJSON(models.Message{Type: mtype, Dst: "peer2"})
+		assert.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		mq := p.realm.GetMessageQueueByID("peer2")
+		return mq != nil && len(mq.GetMessages()) == 3
+	}, time.Second, time.Millisecond*10)
+
+	// a single EXPIRE is sent back once ExpireTimeout is elapsed
+	c1.SetReadDeadline(time.Now().Add(time.Second * 2))
+	msg := models.Message{}
+	err = c1.ReadJSON(&msg)
+	assert.NoError(t, err)
+	assert.Equal(t, MessageTypeExpire, msg.Type)
+	assert.Equal(t, "peer2", msg.Src)
+	assert.GreaterOrEqual(t, time.Since(sentAt), time.Millisecond*time.Duration(opts.ExpireTimeout))
+	assert.Nil(t, p.realm.GetMessageQueueByID("peer2"))
+
+	c1.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
+	err = c1.ReadJSON(&msg)
+	assert.Error(t, err)
+
+	// the expired messages are not delivered
+	c2 := testDialWS(t, srv, opts.Key, "peer2", "token")
+	defer c2.Close()
+	c2.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
+	err = c2.ReadJSON(&msg)
+	assert.Error(t, err)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// Stats snapshot of the websocket server counters
+type Stats struct {
+	// ActiveConnections number of clients currently connected
+	ActiveConnections int64
+	// TotalConnections number of client connections accepted since start
+	TotalConnections int64
+	// MessagesIn number of messages received from clients
+	MessagesIn int64
+	// MessagesOut number of messages sent to clients
+	MessagesOut int64
+	// Errors number of errors reading, decoding or sending messages
+	Errors int64
+}
+
+// wssStats counters updated by the websocket server
+type wssStats struct {
+	totalConnections int64
+	messagesIn       int64
+	messagesOut      int64
+	errors           int64
+}
+
+func (s *wssStats) addConnection() {
+	atomic.AddInt64(&s.totalConnections, 1)
+}
+
+func (s *wssStats) addMessageIn() {
+	atomic.AddInt64(&s.messagesIn, 1)
+}
+
+func (s *wssStats) addMessageOut() {
+	atomic.AddInt64(&s.messagesOut, 1)
+}
+
+func (s *wssStats) addError() {
+	atomic.AddInt64(&s.errors, 1)
+}
+
+func (s *wssStats) snapshot(activeConnections int) Stats {
+	return Stats{
+		ActiveConnections: int64(activeConnections),
+		TotalConnections:  atomic.LoadInt64(&s.totalConnections),
+		MessagesIn:        atomic.LoadInt64(&s.messagesIn),
+		MessagesOut:       atomic.LoadInt64(&s.messagesOut),
+		Errors:            atomic.LoadInt64(&s.errors),
+	}
+}
+
+// MetricsHandler expose the websocket server stats in the Prometheus text format
+func MetricsHandler(wss *WebSocketServer) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		stats := wss.Stats()
+		metrics := []struct {
+			name  string
+			kind  string
+			help  string
+			value int64
+		}{
+			{"peerjs_active_connections", "gauge", "Number of clients currently connected.", stats.ActiveConnections},
+			{"peerjs_connections_total", "counter", "Number of client connections accepted.", stats.TotalConnections},
+			{"peerjs_messages_in_total", "counter", "Number of messages received from clients.", stats.MessagesIn},
+			{"peerjs_messages_out_total", "counter", "Number of messages sent to clients.", stats.MessagesOut},
+			{"peerjs_errors_total", "counter", "Number of errors reading, decoding or sending messages.", stats.Errors},
+		}
+		rw.Header().Add("content-type", "text/plain; version=0.0.4")
+		for _, m := range metrics {
+			fmt.Fprintf(rw, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultTurnTTL default validity in seconds of the TURN credentials
+const DefaultTurnTTL = 86400
+
+// TurnCredentials time-limited TURN credentials, following the TURN REST API
+// scheme supported by coturn with use-auth-secret
+type TurnCredentials struct {
+	Username   string   `json:"username"`
+	Credential string   `json:"credential"`
+	TTL        int64    `json:"ttl"`
+	URLs       []string `json:"urls"`
+}
+
+// NewTurnCredentials generate credentials for clientID valid for ttl seconds.
+// The username is expiry:clientID and the credential the base64 encoded
+// HMAC-SHA1 of the username with secret
+func NewTurnCredentials(secret string, clientID string, ttl int64, urls []string) TurnCredentials {
+	username := fmt.Sprintf("%d:%s", time.Now().Unix()+ttl, clientID)
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return TurnCredentials{
+		Username:   username,
+		Credential: base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		TTL:        ttl,
+		URLs:       urls,
+	}
+}
+
+func (h *HTTPServer) turnHandler() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["key"] != h.opts.Key {
+			http.Error(rw, ErrorInvalidKey, http.StatusUnauthorized)
+			return
+		}
+
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(rw, "Missing client id", http.StatusBadRequest)
+			return
+		}
+
+		ttl := h.opts.TurnTTL
+		if ttl <= 0 {
+			ttl = DefaultTurnTTL
+		}
+
+		raw, err := json.Marshal(NewTurnCredentials(h.opts.TurnSecret, id, ttl, h.opts.TurnURLs))
+		if err != nil {
+			h.log.Warnf("/turn: Marshal error %s", err)
+			rw.WriteHeader(http.StatusInternalServerError)
+			rw.Write([]byte{})
+			return
+		}
+		rw.Header().Add("content-type", "application/json")
+		rw.Write(raw)
+	}
+}
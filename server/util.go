@@ -1,18 +1,148 @@
 package server
 
 import (
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+// DefaultIDPattern default pattern of the client ids
+const DefaultIDPattern = `^[A-Za-z0-9_-]{1,64}$`
+
+// maxGenerateIDAttempts number of generated ids tried before giving up
+const maxGenerateIDAttempts = 10
+
+// clientIP return the address of the client sending r. If trustProxy is set
+// and the request comes from a trusted proxy, the X-Forwarded-For header is
+// walked from the right skipping the trusted proxies and the first other
+// address is used, the rightmost one if no proxy is configured. The X-Real-IP
+// header is used if there is no X-Forwarded-For header
+func clientIP(r *http.Request, trustProxy bool, trusted []*net.IPNet) string {
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+	if !trustProxy || (len(trusted) > 0 && !isTrustedProxy(net.ParseIP(remoteIP), trusted)) {
+		return remoteIP
+	}
+
+	if header := r.Header.Get("X-Forwarded-For"); header != "" {
+		hops := strings.Split(header, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				// not set by a proxy, stop at the last trusted hop
+				break
+			}
+			if i > 0 && isTrustedProxy(ip, trusted) {
+				continue
+			}
+			return ip.String()
+		}
+		return remoteIP
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return remoteIP
+}
+
+// isTrustedProxy check if ip is in one of the trusted networks
+func isTrustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parse the TrustedProxies addresses and CIDR networks,
+// invalid entries are skipped
+func parseTrustedProxies(proxies []string, log Logger) []*net.IPNet {
+	trusted := []*net.IPNet{}
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil {
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip = ip.To4()
+					bits = 8 * net.IPv4len
+				}
+				trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			log.Warnf("Invalid trusted proxy %s, skipping it", proxy)
+			continue
+		}
+		trusted = append(trusted, network)
+	}
+	return trusted
+}
+
+// compileIDPattern compile opts.IDPattern, returns nil if the check is disabled.
+// An invalid pattern falls back to DefaultIDPattern
+func compileIDPattern(opts Options, log Logger) *regexp.Regexp {
+	if opts.IDPattern == "" {
+		return nil
+	}
+	pattern, err := regexp.Compile(opts.IDPattern)
+	if err != nil {
+		log.Errorf("Invalid IDPattern %s, using %s: %s", opts.IDPattern, DefaultIDPattern, err)
+		return regexp.MustCompile(DefaultIDPattern)
+	}
+	return pattern
+}
+
+// generateClientID generate an id matching pattern, if set, and not used by
+// another client
+func generateClientID(realm IRealm, pattern *regexp.Regexp) (string, error) {
+	for i := 0; i < maxGenerateIDAttempts; i++ {
+		id := realm.GenerateClientID()
+		if pattern != nil && !pattern.MatchString(id) {
+			continue
+		}
+		if realm.GetClientByID(id) == nil {
+			return id, nil
+		}
+	}
+	return "", errors.New(ErrorIDGenerationFailed)
+}
+
 // return time in millis
 // credits https://stackoverflow.com/questions/24122821/go-golang-time-now-unixnano-convert-to-milliseconds
 func getTime() int64 {
 	return time.Now().UnixNano() / int64(time.Millisecond)
 }
 
-func createLogger(ctx string, opts Options) *logrus.Entry {
+// Logger is the logging interface used by the server, satisfied by
+// *logrus.Entry and zap's SugaredLogger
+type Logger interface {
+	Debug(args ...interface{})
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// createLogger return opts.Logger if set, otherwise a logrus logger with the
+// context field and LogLevel level
+func createLogger(ctx string, opts Options) Logger {
+	if opts.Logger != nil {
+		return opts.Logger
+	}
+
 	logger := logrus.New()
 	level, err := logrus.ParseLevel(opts.LogLevel)
 	if err != nil {
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		forwarded  string
+		realIP     string
+		trustProxy bool
+		trusted    []string
+		expected   string
+	}{
+		{"", "", false, nil, "192.0.2.1"},
+		{"203.0.113.1", "203.0.113.9", false, nil, "192.0.2.1"},
+		{"203.0.113.1, 198.51.100.1", "", true, nil, "198.51.100.1"},
+		{"unknown, 203.0.113.2", "", true, nil, "203.0.113.2"},
+		{"203.0.113.2, unknown", "", true, nil, "192.0.2.1"},
+		{"", "203.0.113.9", true, nil, "203.0.113.9"},
+		{"", "invalid", true, nil, "192.0.2.1"},
+		{"2001:db8::1", "", true, nil, "2001:db8::1"},
+		// spoofed leftmost entries are skipped past the trusted proxies
+		{"203.0.113.66, 203.0.113.1, 10.0.0.2", "", true, []string{"192.0.2.1", "10.0.0.0/8"}, "203.0.113.1"},
+		{"203.0.113.1, 10.0.0.2", "", true, []string{"192.0.2.0/24", "10.0.0.2"}, "203.0.113.1"},
+		{"10.0.0.3, 10.0.0.2", "", true, []string{"192.0.2.1", "10.0.0.0/8"}, "10.0.0.3"},
+		{"203.0.113.1", "203.0.113.9", true, []string{"198.51.100.1"}, "192.0.2.1"},
+		{"", "203.0.113.9", true, []string{"192.0.2.1"}, "203.0.113.9"},
+		{"203.0.113.1", "", true, []string{"invalid", "192.0.2.1"}, "203.0.113.1"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/peerjs", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if test.realIP != "" {
+			r.Header.Set("X-Real-IP", test.realIP)
+		}
+		trusted := parseTrustedProxies(test.trusted, createLogger("test", NewOptions()))
+		assert.Equal(t, test.expected, clientIP(r, test.trustProxy, trusted), "X-Forwarded-For=%q X-Real-IP=%q trusted=%v", test.forwarded, test.realIP, test.trusted)
+	}
+}
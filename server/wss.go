@@ -1,19 +1,28 @@
 package server
 
 import (
-	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
-	"io/ioutil"
+	"fmt"
+	"net"
 	"net/http"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/muka/peerjs-go/emitter"
 	"github.com/muka/peerjs-go/models"
-	"github.com/sirupsen/logrus"
 )
 
+// DefaultMaxMessageSize max size in bytes of a message received from a client
+const DefaultMaxMessageSize = 64 * 1024
+
+// DefaultWriteTimeout ms after which a write to a client fails
+const DefaultWriteTimeout = 5000
+
 // ClientMessage wrap a message received by a client
 type ClientMessage struct {
 	Client  IClient
@@ -23,17 +32,26 @@ type ClientMessage struct {
 // NewWebSocketServer create a new WebSocketServer
 func NewWebSocketServer(realm IRealm, opts Options) *WebSocketServer {
 	wss := WebSocketServer{
-		Emitter:  emitter.NewEmitter(),
-		upgrader: websocket.Upgrader{},
-		log:      createLogger("websocket-server", opts),
-		realm:    realm,
-		opts:     opts,
+		Emitter: emitter.NewEmitter(),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  opts.ReadBufferSize,
+			WriteBufferSize: opts.WriteBufferSize,
+			Subprotocols:    opts.Subprotocols,
+		},
+		log:     createLogger("websocket-server", opts),
+		clients: map[string]*Conn{},
+		ipConns: map[string]int{},
+		rooms:   map[string]map[string]bool{},
+		realm:   realm,
+		opts:    opts,
 	}
 
-	wss.upgrader.CheckOrigin = func(r *http.Request) bool {
-		// TODO: expose as options
-		return true
-	}
+	wss.upgrader.CheckOrigin = wss.checkOrigin
+	wss.idPattern = compileIDPattern(opts, wss.log)
+	wss.trustedProxies = parseTrustedProxies(opts.TrustedProxies, wss.log)
+
+	wss.checkBrokenConnections = NewCheckBrokenConnections(realm, opts, wss.onClientExpired)
+	wss.checkBrokenConnections.Start()
 
 	return &wss
 }
@@ -42,16 +60,45 @@ func NewWebSocketServer(realm IRealm, opts Options) *WebSocketServer {
 type WebSocketServer struct {
 	emitter.Emitter
 	upgrader websocket.Upgrader
-	clients  []*websocket.Conn
+	clients  map[string]*Conn
+	ipConns  map[string]int
+	rooms    map[string]map[string]bool
 	cMutex   sync.Mutex
-	log      *logrus.Entry
+	log      Logger
 	realm    IRealm
 	opts     Options
+	// checkBrokenConnections evicts clients not sending messages within AliveTimeout
+	checkBrokenConnections *CheckBrokenConnections
+	stats                  wssStats
+	idPattern              *regexp.Regexp
+	trustedProxies         []*net.IPNet
+}
+
+// Stats return a snapshot of the server counters
+func (wss *WebSocketServer) Stats() Stats {
+	wss.cMutex.Lock()
+	active := len(wss.clients)
+	wss.cMutex.Unlock()
+	return wss.stats.snapshot(active)
+}
+
+// emitError count and emit an error event
+func (wss *WebSocketServer) emitError(err error) {
+	wss.stats.addError()
+	wss.Emit(WebsocketEventError, err)
 }
 
-// Send send data to the clients
+// Send send data to the clients. Clients failing to receive it within the
+// WriteTimeout are disconnected, the others still receive it
 func (wss *WebSocketServer) Send(data []byte) {
+	wss.cMutex.Lock()
+	conns := make([]*Conn, 0, len(wss.clients))
 	for _, conn := range wss.clients {
+		conns = append(conns, conn)
+	}
+	wss.cMutex.Unlock()
+
+	for _, conn := range conns {
 		err := conn.WriteMessage(websocket.BinaryMessage, data)
 		if err != nil {
 			wss.log.Warnf("Write failed: %s", err)
@@ -59,26 +106,157 @@ func (wss *WebSocketServer) Send(data []byte) {
 	}
 }
 
-// onSocketConnection called when a client connect
-func (wss *WebSocketServer) sendErrorAndClose(conn *websocket.Conn, msg string) error {
+// SendTo send a server message to a client, eg. a notification or a custom
+// control message. Clients connected to another instance of a remote realm
+// receive it with Deliver
+func (wss *WebSocketServer) SendTo(clientID string, msg *models.Message) error {
+	client := wss.realm.GetClientByID(clientID)
+	if client == nil {
+		return ErrClientNotConnected
+	}
+	socket := client.GetSocket()
+	if socket == nil {
+		if remoteRealm, ok := wss.realm.(IRemoteRealm); ok {
+			message := *msg
+			message.Dst = clientID
+			return remoteRealm.Deliver(message)
+		}
+		return ErrClientNotConnected
+	}
+	return socket.WriteJSON(msg)
+}
+
+// Close sends a going away close message to the connected clients and closes
+// their connections
+func (wss *WebSocketServer) Close() error {
+	wss.checkBrokenConnections.Stop()
+
+	wss.cMutex.Lock()
+	clients := wss.clients
+	wss.clients = map[string]*Conn{}
+	wss.rooms = map[string]map[string]bool{}
+	wss.cMutex.Unlock()
+
+	for clientID, conn := range clients {
+		err := conn.WriteMessage(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "Server shutting down"),
+		)
+		if err != nil {
+			wss.log.Debugf("[%s] Failed to send close message: %s", clientID, err)
+		}
+		err = conn.Close()
+		if err != nil {
+			wss.log.Debugf("[%s] Failed to close connection: %s", clientID, err)
+		}
+		wss.realm.RemoveClientByID(clientID)
+	}
+
+	return nil
+}
+
+// checkOrigin match the request origin against the allowed origins
+func (wss *WebSocketServer) checkOrigin(r *http.Request) bool {
+	if len(wss.opts.AllowedOrigins) == 0 {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	for _, allowed := range wss.opts.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	wss.log.Debugf("Origin %s not allowed", origin)
+	return false
+}
+
+// addConn track the connection of a client, replacing a previous one
+func (wss *WebSocketServer) addConn(clientID string, conn *Conn) {
+	wss.cMutex.Lock()
+	defer wss.cMutex.Unlock()
+	wss.clients[clientID] = conn
+}
+
+// acquireIP count a connection from ip, returns false if MaxConnectionsPerIP
+// connections are already open
+func (wss *WebSocketServer) acquireIP(ip string) bool {
+	wss.cMutex.Lock()
+	defer wss.cMutex.Unlock()
+	if wss.ipConns[ip] >= wss.opts.MaxConnectionsPerIP {
+		return false
+	}
+	wss.ipConns[ip]++
+	return true
+}
+
+// releaseIP discount a closed connection from ip
+func (wss *WebSocketServer) releaseIP(ip string) {
+	wss.cMutex.Lock()
+	defer wss.cMutex.Unlock()
+	wss.ipConns[ip]--
+	if wss.ipConns[ip] <= 0 {
+		delete(wss.ipConns, ip)
+	}
+}
+
+// removeConn stop tracking the connection of a client. Returns false if conn
+// is not the tracked one, eg. the client has already reconnected
+func (wss *WebSocketServer) removeConn(clientID string, conn *Conn) bool {
+	wss.cMutex.Lock()
+	defer wss.cMutex.Unlock()
+	if c, ok := wss.clients[clientID]; !ok || c != conn {
+		return false
+	}
+	delete(wss.clients, clientID)
+	wss.leaveRooms(clientID)
+	return true
+}
+
+// removeClient cleanup a disconnected client
+func (wss *WebSocketServer) removeClient(client IClient, conn *Conn) {
+	conn.Close()
+	if !wss.removeConn(client.GetID(), conn) {
+		return
+	}
+	// make sure to remove them from the realm so they can connect again.
+	wss.realm.RemoveClientByID(client.GetID())
+	wss.Emit(WebsocketEventClose, client)
+}
+
+// onClientExpired cleanup a client evicted for inactivity
+func (wss *WebSocketServer) onClientExpired(client IClient) {
+	wss.cMutex.Lock()
+	_, ok := wss.clients[client.GetID()]
+	delete(wss.clients, client.GetID())
+	wss.leaveRooms(client.GetID())
+	wss.cMutex.Unlock()
+	if !ok {
+		// already removed by the read loop
+		return
+	}
+	wss.Emit(WebsocketEventClose, client)
+}
+
+// sendErrorAndClose send an error message of errType and close the connection
+func (wss *WebSocketServer) sendErrorAndClose(conn *Conn, errType, msg string) error {
 	err := conn.WriteJSON(models.Message{
 		Type: MessageTypeError,
 		Payload: models.Payload{
-			Msg: msg,
+			Type: errType,
+			Msg:  msg,
 		},
 	})
+	closeErr := conn.Close()
 	if err != nil {
 		return err
 	}
-	err = conn.Close()
-	if err != nil {
-		return err
-	}
-	return nil
+	return closeErr
 }
 
-func (wss *WebSocketServer) configureWS(conn *websocket.Conn, client IClient) error {
+func (wss *WebSocketServer) configureWS(conn *Conn, client IClient) error {
 	client.SetSocket(conn)
+	wss.addConn(client.GetID(), conn)
+	wss.stats.addConnection()
 
 	conn.SetPingHandler(func(appData string) error {
 		// wss.log.Debugf("[%s] Ping received", client.GetID())
@@ -86,49 +264,81 @@ func (wss *WebSocketServer) configureWS(conn *websocket.Conn, client IClient) er
 		return nil
 	})
 
-	clientID := client.GetID()
-	closed := false
 	conn.SetCloseHandler(func(code int, text string) error {
-		// if any close error happens, stop the loop and remove the client
+		// the read loop will receive a close error and remove the client
 		wss.log.Debugf("Closed connection, cleaning up %s", client.GetID())
-		if client.GetSocket() == conn { // NOTE note sure why this check is needed, to find out
-			wss.realm.RemoveClientByID(clientID)
-		}
-		conn.Close()
-		wss.Emit(WebsocketEventClose, client)
-		closed = true
 		return nil
 	})
 
+	// oversized messages are rejected by gorilla with a "message too big" close
+	readLimit := wss.opts.MaxMessageSize
+	if readLimit <= 0 {
+		readLimit = DefaultMaxMessageSize
+	}
+	conn.SetReadLimit(readLimit)
+
+	var limiter *rateLimiter
+	if wss.opts.MessageRateLimit > 0 {
+		limiter = newRateLimiter(wss.opts.MessageRateLimit, wss.opts.MessageBurst)
+	}
+
+	// identify the client in the read loop logs
+	logID := fmt.Sprintf("%s@%s", client.GetID(), conn.RemoteIP())
+
 	go func() {
 		for {
-			if closed {
-				return
-			}
 			_, raw, err := conn.ReadMessage()
 			if err != nil {
-				wss.log.Errorf("[%s] Read WS error: %s", client.GetID(), err)
-				// If they disconnected, let's make sure to remove them from
-				// the realm so they can connect again.
-				wss.realm.RemoveClientByID(clientID)
-				conn.Close()
-				wss.Emit(WebsocketEventClose, client)
+				if err == websocket.ErrReadLimit {
+					wss.log.Warnf("[%s] Closing connection, message exceeds %d bytes", logID, readLimit)
+					wss.emitError(fmt.Errorf("[%s] %s", client.GetID(), err))
+				} else {
+					wss.log.Errorf("[%s] Read WS error: %s", logID, err)
+				}
+				wss.removeClient(client, conn)
 				return
 			}
 
-			// message handling
-			data, err := ioutil.ReadAll(bytes.NewReader(raw))
-			if err != nil {
-				wss.log.Errorf("client message read error: %s", err)
-				wss.Emit(WebsocketEventError, err)
+			if limiter != nil && !limiter.Allow() {
+				if limiter.Abused() {
+					wss.log.Warnf("[%s] Closing connection, message rate limit exceeded", logID)
+					err := wss.sendErrorAndClose(conn, ErrorTypeRateLimit, ErrorRateLimitExceeded)
+					if err != nil {
+						wss.log.Errorf("[sendErrorAndClose] Error: %s", err)
+					}
+					wss.removeClient(client, conn)
+					return
+				}
+				wss.emitError(fmt.Errorf("[%s] %s, message dropped", client.GetID(), ErrorRateLimitExceeded))
 				continue
 			}
 
+			wss.stats.addMessageIn()
+
+			// message handling
 			message := new(models.Message)
-			err = json.Unmarshal(data, message)
+			err = json.Unmarshal(raw, message)
 			if err != nil {
-				wss.log.Errorf("client message unmarshal error: %s", err)
-				wss.Emit(WebsocketEventError, err)
+				wss.log.Errorf("client message unmarshal error from %s: %s", logID, err)
+				wss.emitError(err)
+				continue
+			}
+
+			// any message counts as activity, heartbeats are not forwarded
+			client.SetLastPing(getTime())
+			if message.Type == MessageTypeHeartbeat {
+				if message.Payload.Msg == "" {
+					continue
+				}
+				// echo heartbeats carrying a sequence number, allowing the
+				// client to measure the round trip
+				err := conn.WriteJSON(models.Message{
+					Type:    MessageTypeHeartbeat,
+					Payload: message.Payload,
+				})
+				if err != nil {
+					wss.log.Debugf("[%s] Failed to reply heartbeat: %s", logID, err)
+				}
 				continue
 			}
 
@@ -141,13 +351,46 @@ func (wss *WebSocketServer) configureWS(conn *websocket.Conn, client IClient) er
 	return nil
 }
 
-// registerClient
-func (wss *WebSocketServer) registerClient(conn *websocket.Conn, id, token string) error {
+// generateToken generate a random token from a secure source
+func generateToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// generateToken mint the token of a client connecting without one, with the
+// TokenGenerator if set
+func (wss *WebSocketServer) generateToken(id string) (string, error) {
+	if wss.opts.TokenGenerator != nil {
+		return wss.opts.TokenGenerator(id)
+	}
+	return generateToken()
+}
+
+// validateToken check the token presented by a client. A registered client
+// must present the same token, a new one is checked with the TokenValidator
+// if set or accepted
+func (wss *WebSocketServer) validateToken(id, token string, client IClient) (bool, error) {
+	if client != nil {
+		return token == client.GetToken(), nil
+	}
+	if wss.opts.TokenValidator != nil {
+		return wss.opts.TokenValidator(id, token)
+	}
+	return true, nil
+}
+
+// registerClient register a new client, sending back id and token in the OPEN
+// payload if generated by the server
+func (wss *WebSocketServer) registerClient(conn *Conn, id, token string, generated bool) error {
 	// Check concurrent limit
 	clientsCount := len(wss.realm.GetClientsIds())
 
 	if clientsCount >= wss.opts.ConcurrentLimit {
-		err := wss.sendErrorAndClose(conn, ErrorConnectionLimitExceeded)
+		err := wss.sendErrorAndClose(conn, ErrorTypeConnectionLimit, ErrorConnectionLimitExceeded)
 		if err != nil {
 			wss.log.Errorf("[sendErrorAndClose] Error: %s", err)
 		}
@@ -157,7 +400,11 @@ func (wss *WebSocketServer) registerClient(conn *websocket.Conn, id, token strin
 	client := NewClient(id, token)
 	wss.realm.SetClient(client, id)
 
-	err := conn.WriteJSON(models.Message{Type: MessageTypeOpen})
+	open := models.Message{Type: MessageTypeOpen}
+	if generated {
+		open.Payload = models.Payload{ID: id, Token: token}
+	}
+	err := conn.WriteJSON(open)
 	if err != nil {
 		return err
 	}
@@ -170,39 +417,119 @@ func (wss *WebSocketServer) registerClient(conn *websocket.Conn, id, token strin
 }
 
 // onSocketConnection called when a client connect
-func (wss *WebSocketServer) onSocketConnection(conn *websocket.Conn, r *http.Request) {
+func (wss *WebSocketServer) onSocketConnection(conn *Conn, r *http.Request) {
 	query := r.URL.Query()
 	id := query.Get("id")
 	token := query.Get("token")
 	key := query.Get("key")
 
-	if id == "" || token == "" || key == "" {
-		err := wss.sendErrorAndClose(conn, ErrorInvalidWSParameters)
+	if key == "" || ((id == "" || token == "") && !wss.opts.AllowServerGeneratedIDs) {
+		err := wss.sendErrorAndClose(conn, ErrorTypeInvalidWSParameters, ErrorInvalidWSParameters)
 		if err != nil {
 			wss.log.Errorf("[sendErrorAndClose] Error: %s", err)
 		}
 		return
 	}
 
-	if key != wss.opts.Key {
-		err := wss.sendErrorAndClose(conn, ErrorInvalidKey)
+	// the AuthHandler, if set, replaces the key check
+	if wss.opts.AuthHandler == nil && key != wss.opts.Key {
+		err := wss.sendErrorAndClose(conn, ErrorTypeInvalidKey, ErrorInvalidKey)
 		if err != nil {
 			wss.log.Errorf("[sendErrorAndClose] Error: %s", err)
 		}
 		return
 	}
 
+	generated := id == "" || token == ""
+	tokenGenerated := token == ""
+	if generated {
+		var err error
+		if id == "" {
+			id, err = generateClientID(wss.realm, wss.idPattern)
+		}
+		if err == nil && token == "" {
+			token, err = wss.generateToken(id)
+		}
+		if err != nil {
+			wss.log.Errorf("Failed to generate client credentials: %s", err)
+			err = wss.sendErrorAndClose(conn, ErrorTypeServerError, ErrorIDGenerationFailed)
+			if err != nil {
+				wss.log.Errorf("[sendErrorAndClose] Error: %s", err)
+			}
+			return
+		}
+	}
+
+	if wss.idPattern != nil && !wss.idPattern.MatchString(id) {
+		err := wss.sendErrorAndClose(conn, ErrorTypeInvalidID, ErrorInvalidID)
+		if err != nil {
+			wss.log.Errorf("[sendErrorAndClose] Error: %s", err)
+		}
+		return
+	}
+
+	if wss.opts.AuthHandler != nil {
+		err := wss.opts.AuthHandler(id, token, key, r)
+		if err != nil {
+			wss.log.Debugf("[%s] Authentication failed: %s", id, err)
+			err = wss.sendErrorAndClose(conn, ErrorTypeUnauthorized, err.Error())
+			if err != nil {
+				wss.log.Errorf("[sendErrorAndClose] Error: %s", err)
+			}
+			return
+		}
+	}
+
+	if wss.opts.MaxConnectionsPerIP > 0 {
+		ip := conn.RemoteIP()
+		if !wss.acquireIP(ip) {
+			wss.log.Warnf("[%s] Rejecting connection, too many connections from %s", id, ip)
+			err := wss.sendErrorAndClose(conn, ErrorTypeIPConnectionLimit, ErrorIPConnectionLimitExceeded)
+			if err != nil {
+				wss.log.Errorf("[sendErrorAndClose] Error: %s", err)
+			}
+			return
+		}
+		conn.onClose = func() {
+			wss.releaseIP(ip)
+		}
+	}
+
 	client := wss.realm.GetClientByID(id)
 
+	// a token minted by the server can not match a registered client
+	valid := client == nil
+	if !tokenGenerated {
+		var err error
+		valid, err = wss.validateToken(id, token, client)
+		if err != nil {
+			wss.log.Warnf("[%s] Token validation failed: %s", id, err)
+			err = wss.sendErrorAndClose(conn, ErrorTypeInvalidToken, ErrorInvalidToken)
+			if err != nil {
+				wss.log.Errorf("[sendErrorAndClose] Error: %s", err)
+			}
+			return
+		}
+	}
+
+	if !valid && client == nil {
+		err := wss.sendErrorAndClose(conn, ErrorTypeInvalidToken, ErrorInvalidToken)
+		if err != nil {
+			wss.log.Errorf("[sendErrorAndClose] Error: %s", err)
+		}
+		return
+	}
+
 	if client == nil {
-		err := wss.registerClient(conn, id, token)
+		err := wss.registerClient(conn, id, token, generated)
 		if err != nil {
 			wss.log.Errorf("[registerClient] Error: %s", err)
+			conn.Close()
 		}
 		return
 	}
 
-	if token != client.GetToken() {
+	if !valid {
 		// ID-taken, invalid token
 		err := conn.WriteJSON(models.Message{
 			Type: MessageTypeIDTaken,
@@ -221,7 +548,38 @@ func (wss *WebSocketServer) onSocketConnection(conn *websocket.Conn, r *http.Req
 		return
 	}
 
-	wss.configureWS(conn, client)
+	err := wss.reconnectClient(conn, client)
+	if err != nil {
+		wss.log.Errorf("[reconnectClient] Error: %s", err)
+		conn.Close()
+	}
+}
+
+// reconnectClient associates a new connection to a known client presenting the
+// matching token, closing the previous connection if still open
+func (wss *WebSocketServer) reconnectClient(conn *Conn, client IClient) error {
+	prev := client.GetSocket()
+
+	if _, ok := wss.realm.(IRemoteRealm); ok && prev == nil {
+		// the client may be connected to another instance, register it here
+		wss.realm.SetClient(client, client.GetID())
+	}
+
+	err := conn.WriteJSON(models.Message{Type: MessageTypeOpen})
+	if err != nil {
+		return err
+	}
+
+	err = wss.configureWS(conn, client)
+	if err != nil {
+		return err
+	}
+
+	if prev != nil && prev != conn {
+		wss.log.Debugf("[%s] Client reconnected, closing previous connection", client.GetID())
+		prev.Close()
+	}
+	return nil
 }
 
 // Handler expose the http handler for websocket
@@ -235,6 +593,23 @@ func (wss *WebSocketServer) Handler() http.HandlerFunc {
 			// next.ServeHTTP(w, r)
 			return
 		}
-		wss.onSocketConnection(c, r)
+		conn := NewConn(c)
+		conn.stats = &wss.stats
+		conn.writeTimeout = time.Duration(wss.opts.WriteTimeout) * time.Millisecond
+		if conn.writeTimeout <= 0 {
+			conn.writeTimeout = DefaultWriteTimeout * time.Millisecond
+		}
+		conn.ip = clientIP(r, wss.opts.TrustProxy, wss.trustedProxies)
+		wss.onSocketConnection(conn, r)
 	})
 }
+
+// OnMessage register a handler for the messages received from the clients
+func (wss *WebSocketServer) OnMessage(handler func(ClientMessage)) {
+	emitter.OnTyped(wss, WebsocketEventMessage, handler)
+}
+
+// OnClose register a handler called when a client disconnects
+func (wss *WebSocketServer) OnClose(handler func(IClient)) {
+	emitter.OnTyped(wss, WebsocketEventClose, handler)
+}
@@ -0,0 +1,838 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/muka/peerjs-go/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func testStartWSS(opts Options) (*WebSocketServer, *httptest.Server) {
+	wss := NewWebSocketServer(NewRealm(), opts)
+	return wss, testServeWSS(wss)
+}
+
+func testServeWSS(wss *WebSocketServer) *httptest.Server {
+	return httptest.NewServer(wss.Handler())
+}
+
+func testDialWS(t *testing.T, srv *httptest.Server, key, id, token string) *websocket.Conn {
+	url := fmt.Sprintf(
+		"ws%s/peerjs?key=%s&id=%s&token=%s",
+		strings.TrimPrefix(srv.URL, "http"),
+		key,
+		id,
+		token,
+	)
+	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := models.Message{}
+	err = c.ReadJSON(&msg)
+	assert.NoError(t, err)
+	assert.Equal(t, MessageTypeOpen, msg.Type)
+	return c
+}
+
+func TestWebSocketServerConcurrentSend(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	wss, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	clients := []*websocket.Conn{}
+	for i := 0; i < 5; i++ {
+		clients = append(clients, testDialWS(t, srv, opts.Key, fmt.Sprintf("client%d", i), "token"))
+	}
+
+	stop := make(chan bool)
+	wg := sync.WaitGroup{}
+	for _, c := range clients {
+		wg.Add(2)
+		// heartbeats
+		go func(c *websocket.Conn) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(time.Millisecond * 5):
+					c.WriteJSON(models.Message{Type: MessageTypeHeartbeat})
+				}
+			}
+		}(c)
+		// drain broadcasts
+		go func(c *websocket.Conn) {
+			defer wg.Done()
+			for {
+				if _, _, err := c.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}(c)
+	}
+
+	senders := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		senders.Add(1)
+		go func(i int) {
+			defer senders.Done()
+			for j := 0; j < 50; j++ {
+				wss.Send([]byte(fmt.Sprintf("broadcast %d:%d", i, j)))
+			}
+		}(i)
+	}
+	senders.Wait()
+
+	close(stop)
+	for _, c := range clients {
+		c.Close()
+	}
+	wg.Wait()
+}
+
+func testCountConns(wss *WebSocketServer) int {
+	wss.cMutex.Lock()
+	defer wss.cMutex.Unlock()
+	return len(wss.clients)
+}
+
+func TestWebSocketServerRemoveClosedClients(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	wss, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	clients := []*websocket.Conn{}
+	for i := 0; i < 10; i++ {
+		clients = append(clients, testDialWS(t, srv, opts.Key, fmt.Sprintf("client%d", i), "token"))
+	}
+	assert.Equal(t, 10, testCountConns(wss))
+
+	for i, c := range clients {
+		if i%2 == 0 {
+			// clean close
+			c.WriteMessage(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			)
+		}
+		c.Close()
+	}
+
+	assert.Eventually(t, func() bool {
+		return testCountConns(wss) == 0
+	}, time.Second*2, time.Millisecond*10)
+	assert.Empty(t, wss.realm.GetClientsIds())
+}
+
+func TestWebSocketServerEvictIdleClients(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	opts.AliveTimeout = 200
+	wss, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	closed := make(chan IClient, 2)
+	wss.OnClose(func(client IClient) {
+		closed <- client
+	})
+	messages := make(chan ClientMessage, 10)
+	wss.OnMessage(func(message ClientMessage) {
+		messages <- message
+	})
+
+	idle := testDialWS(t, srv, opts.Key, "idle", "token")
+	defer idle.Close()
+	alive := testDialWS(t, srv, opts.Key, "alive", "token")
+	defer alive.Close()
+
+	stop := make(chan bool)
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond * 50):
+				alive.WriteJSON(models.Message{Type: MessageTypeHeartbeat})
+			}
+		}
+	}()
+
+	select {
+	case client := <-closed:
+		assert.Equal(t, "idle", client.GetID())
+	case <-time.After(time.Second * 2):
+		t.Fatal("idle client not evicted")
+	}
+
+	<-time.After(time.Millisecond * 500)
+	assert.Empty(t, closed)
+	assert.Equal(t, 1, testCountConns(wss))
+	assert.Equal(t, []string{"alive"}, wss.realm.GetClientsIds())
+	// heartbeats are not forwarded as messages
+	assert.Empty(t, messages)
+}
+
+func TestWebSocketServerAllowedOrigins(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	opts.AllowedOrigins = []string{"https://allowed.example.com"}
+	_, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	dial := func(id, origin string) (*websocket.Conn, error) {
+		url := fmt.Sprintf(
+			"ws%s/peerjs?key=%s&id=%s&token=token",
+			strings.TrimPrefix(srv.URL, "http"),
+			opts.Key,
+			id,
+		)
+		header := http.Header{}
+		header.Set("Origin", origin)
+		c, _, err := websocket.DefaultDialer.Dial(url, header)
+		return c, err
+	}
+
+	c, err := dial("allowed", "https://allowed.example.com")
+	assert.NoError(t, err)
+	if c != nil {
+		c.Close()
+	}
+
+	_, err = dial("rejected", "https://other.example.com")
+	assert.Error(t, err)
+}
+
+func TestWebSocketServerClose(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	wss, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	c := testDialWS(t, srv, opts.Key, "client", "token")
+	defer c.Close()
+
+	err := wss.Close()
+	assert.NoError(t, err)
+
+	_, _, err = c.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "unexpected error %v", err)
+	assert.Equal(t, 0, testCountConns(wss))
+	assert.Empty(t, wss.realm.GetClientsIds())
+}
+
+func TestWebSocketServerRateLimit(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	opts.MessageRateLimit = 5
+	opts.MessageBurst = 5
+	wss, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	received := make(chan ClientMessage, 1000)
+	wss.On(WebsocketEventMessage, func(data interface{}) {
+		received <- data.(ClientMessage)
+	})
+	dropped := make(chan error, 1000)
+	wss.On(WebsocketEventError, func(data interface{}) {
+		dropped <- data.(error)
+	})
+
+	c := testDialWS(t, srv, opts.Key, "flooder", "token")
+	defer c.Close()
+
+	for i := 0; i < 20; i++ {
+		c.WriteJSON(models.Message{Type: MessageTypeOffer, Dst: "other"})
+	}
+	assert.Eventually(t, func() bool {
+		return len(received)+len(dropped) == 20
+	}, time.Second, time.Millisecond*10)
+	assert.InDelta(t, 5, len(received), 1)
+
+	// sustained abuse closes the connection
+	for i := 0; i < MaxRateLimitedMessages*2; i++ {
+		c.WriteJSON(models.Message{Type: MessageTypeOffer, Dst: "other"})
+	}
+	msg := models.Message{}
+	err := c.ReadJSON(&msg)
+	assert.NoError(t, err)
+	assert.Equal(t, MessageTypeError, msg.Type)
+	assert.Equal(t, ErrorRateLimitExceeded, msg.Payload.Msg)
+	assert.Eventually(t, func() bool {
+		return testCountConns(wss) == 0
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestWebSocketServerMaxMessageSize(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	opts.MaxMessageSize = 1024
+	wss, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	errs := make(chan error, 1)
+	wss.On(WebsocketEventError, func(data interface{}) {
+		errs <- data.(error)
+	})
+
+	c := testDialWS(t, srv, opts.Key, "client", "token")
+	defer c.Close()
+
+	err := c.WriteJSON(models.Message{
+		Type:    MessageTypeOffer,
+		Dst:     "other",
+		Payload: models.Payload{Msg: strings.Repeat("x", 2048)},
+	})
+	assert.NoError(t, err)
+
+	_, _, err = c.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), "unexpected error %v", err)
+	assert.Error(t, <-errs)
+	assert.Eventually(t, func() bool {
+		return testCountConns(wss) == 0
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestWebSocketServerStats(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	wss, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	c1 := testDialWS(t, srv, opts.Key, "client1", "token")
+	c2 := testDialWS(t, srv, opts.Key, "client2", "token")
+	defer c2.Close()
+
+	c1.WriteJSON(models.Message{Type: MessageTypeHeartbeat, Payload: models.Payload{Msg: "1"}})
+	c1.WriteMessage(websocket.TextMessage, []byte("not json"))
+	wss.Send([]byte("broadcast"))
+
+	assert.Eventually(t, func() bool {
+		return wss.Stats().MessagesIn == 2
+	}, time.Second, time.Millisecond*10)
+	stats := wss.Stats()
+	assert.Equal(t, int64(2), stats.ActiveConnections)
+	assert.Equal(t, int64(2), stats.TotalConnections)
+	// OPEN messages, heartbeat reply and the broadcast
+	assert.Equal(t, int64(5), stats.MessagesOut)
+	assert.Equal(t, int64(1), stats.Errors)
+
+	c1.Close()
+	assert.Eventually(t, func() bool {
+		return wss.Stats().ActiveConnections == 1
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, int64(2), wss.Stats().TotalConnections)
+
+	rec := httptest.NewRecorder()
+	MetricsHandler(wss)(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), "peerjs_active_connections 1\n")
+	assert.Contains(t, rec.Body.String(), "peerjs_connections_total 2\n")
+}
+
+type testLogger struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) log(format string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Lines() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]string{}, l.lines...)
+}
+
+func (l *testLogger) Debug(args ...interface{})                 { l.log("%s", fmt.Sprint(args...)) }
+func (l *testLogger) Debugf(format string, args ...interface{}) { l.log(format, args...) }
+func (l *testLogger) Infof(format string, args ...interface{})  { l.log(format, args...) }
+func (l *testLogger) Warnf(format string, args ...interface{})  { l.log(format, args...) }
+func (l *testLogger) Errorf(format string, args ...interface{}) { l.log(format, args...) }
+
+func TestWebSocketServerLogger(t *testing.T) {
+	opts := NewOptions()
+	logger := &testLogger{}
+	opts.Logger = logger
+	_, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	c := testDialWS(t, srv, opts.Key, "client", "token")
+	c.WriteMessage(websocket.TextMessage, []byte("not json"))
+
+	assert.Eventually(t, func() bool {
+		for _, line := range logger.Lines() {
+			if strings.HasPrefix(line, "client message unmarshal error") {
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond*10)
+	c.Close()
+}
+
+func TestWebSocketServerGeneratedIDs(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	wss, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	dial := func() models.Message {
+		url := fmt.Sprintf("ws%s/peerjs?key=%s", strings.TrimPrefix(srv.URL, "http"), opts.Key)
+		c, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		msg := models.Message{}
+		err = c.ReadJSON(&msg)
+		assert.NoError(t, err)
+		return msg
+	}
+
+	// rejected by default
+	msg := dial()
+	assert.Equal(t, MessageTypeError, msg.Type)
+	assert.Equal(t, ErrorInvalidWSParameters, msg.Payload.Msg)
+
+	wss.opts.AllowServerGeneratedIDs = true
+	msg = dial()
+	assert.Equal(t, MessageTypeOpen, msg.Type)
+	assert.NotEmpty(t, msg.Payload.ID)
+	assert.Len(t, msg.Payload.Token, 32)
+	client := wss.realm.GetClientByID(msg.Payload.ID)
+	assert.NotNil(t, client)
+	assert.Equal(t, msg.Payload.Token, client.GetToken())
+}
+
+func TestWebSocketServerGeneratedIDCollision(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	opts.AllowServerGeneratedIDs = true
+	wss, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	generator := ClientIDGenerator
+	defer func() {
+		ClientIDGenerator = generator
+	}()
+	ids := []string{"taken", "taken", "free"}
+	ClientIDGenerator = func() string {
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+	wss.realm.SetClient(NewClient("taken", "token"), "taken")
+
+	id, err := generateClientID(wss.realm, wss.idPattern)
+	assert.NoError(t, err)
+	assert.Equal(t, "free", id)
+
+	ClientIDGenerator = func() string {
+		return "taken"
+	}
+	_, err = generateClientID(wss.realm, wss.idPattern)
+	assert.Error(t, err)
+}
+
+func TestWebSocketServerIDPattern(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	_, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	dial := func(id string) models.Message {
+		u := fmt.Sprintf(
+			"ws%s/peerjs?key=%s&id=%s&token=token",
+			strings.TrimPrefix(srv.URL, "http"),
+			opts.Key,
+			url.QueryEscape(id),
+		)
+		c, _, err := websocket.DefaultDialer.Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		msg := models.Message{}
+		err = c.ReadJSON(&msg)
+		assert.NoError(t, err)
+		return msg
+	}
+
+	for _, id := range []string{"peer1", "my_peer-2", strings.Repeat("a", 64)} {
+		assert.Equal(t, MessageTypeOpen, dial(id).Type, "id %s", id)
+	}
+	for _, id := range []string{"bad id", "../peer", "peer\n", strings.Repeat("a", 65)} {
+		msg := dial(id)
+		assert.Equal(t, MessageTypeError, msg.Type, "id %s", id)
+		assert.Equal(t, ErrorInvalidID, msg.Payload.Msg)
+	}
+}
+
+func TestWebSocketServerReconnect(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	wss, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	c1 := testDialWS(t, srv, opts.Key, "peer", "token")
+	defer c1.Close()
+
+	// same token, the client is associated to the new connection
+	c2 := testDialWS(t, srv, opts.Key, "peer", "token")
+	defer c2.Close()
+
+	c1.SetReadDeadline(time.Now().Add(time.Second * 2))
+	_, _, err := c1.ReadMessage()
+	assert.Error(t, err)
+
+	assert.Equal(t, 1, testCountConns(wss))
+	assert.Equal(t, []string{"peer"}, wss.realm.GetClientsIds())
+
+	// heartbeats without a sequence number are not echoed
+	err = c2.WriteJSON(models.Message{Type: MessageTypeHeartbeat})
+	assert.NoError(t, err)
+	err = c2.WriteJSON(models.Message{Type: MessageTypeHeartbeat, Payload: models.Payload{Msg: "1"}})
+	assert.NoError(t, err)
+	msg := models.Message{}
+	err = c2.ReadJSON(&msg)
+	assert.NoError(t, err)
+	assert.Equal(t, MessageTypeHeartbeat, msg.Type)
+	assert.Equal(t, "1", msg.Payload.Msg)
+}
+
+func TestWebSocketServerAuthHandler(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	opts.AuthHandler = func(id, token, key string, r *http.Request) error {
+		if token != "valid" {
+			return fmt.Errorf("Invalid token for %s", id)
+		}
+		return nil
+	}
+	_, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	dial := func(key, token string) models.Message {
+		u := fmt.Sprintf(
+			"ws%s/peerjs?key=%s&id=peer&token=%s",
+			strings.TrimPrefix(srv.URL, "http"),
+			key,
+			token,
+		)
+		c, _, err := websocket.DefaultDialer.Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		msg := models.Message{}
+		err = c.ReadJSON(&msg)
+		assert.NoError(t, err)
+		return msg
+	}
+
+	msg := dial(opts.Key, "invalid")
+	assert.Equal(t, MessageTypeError, msg.Type)
+	assert.Equal(t, "Invalid token for peer", msg.Payload.Msg)
+	assert.Equal(t, ErrorTypeUnauthorized, msg.Payload.Type)
+
+	// the handler replaces the key check
+	msg = dial("another-key", "valid")
+	assert.Equal(t, MessageTypeOpen, msg.Type)
+}
+
+func TestWebSocketServerMaxConnectionsPerIP(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	opts.MaxConnectionsPerIP = 2
+	opts.TrustProxy = true
+	opts.TrustedProxies = []string{"127.0.0.1", "10.0.0.1"}
+	wss, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	dial := func(id, ip string) (*websocket.Conn, models.Message) {
+		u := fmt.Sprintf(
+			"ws%s/peerjs?key=%s&id=%s&token=token",
+			strings.TrimPrefix(srv.URL, "http"),
+			opts.Key,
+			id,
+		)
+		header := http.Header{}
+		header.Set("X-Forwarded-For", ip+", 10.0.0.1")
+		c, _, err := websocket.DefaultDialer.Dial(u, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := models.Message{}
+		err = c.ReadJSON(&msg)
+		assert.NoError(t, err)
+		return c, msg
+	}
+
+	c1, msg := dial("peer1", "192.0.2.1")
+	defer c1.Close()
+	assert.Equal(t, MessageTypeOpen, msg.Type)
+	c2, msg := dial("peer2", "192.0.2.1")
+	defer c2.Close()
+	assert.Equal(t, MessageTypeOpen, msg.Type)
+
+	c3, msg := dial("peer3", "192.0.2.1")
+	c3.Close()
+	assert.Equal(t, MessageTypeError, msg.Type)
+	assert.Equal(t, ErrorIPConnectionLimitExceeded, msg.Payload.Msg)
+
+	// other addresses are not limited
+	c4, msg := dial("peer4", "192.0.2.2")
+	defer c4.Close()
+	assert.Equal(t, MessageTypeOpen, msg.Type)
+
+	// closed connections are released
+	c1.Close()
+	assert.Eventually(t, func() bool {
+		return testCountConns(wss) == 2
+	}, time.Second*2, time.Millisecond*10)
+	c5, msg := dial("peer5", "192.0.2.1")
+	defer c5.Close()
+	assert.Equal(t, MessageTypeOpen, msg.Type)
+}
+
+func TestWebSocketServerSubprotocols(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	opts.Subprotocols = []string{"peerjs", "json"}
+	_, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	dial := func(id string, protocols []string) string {
+		u := fmt.Sprintf(
+			"ws%s/peerjs?key=%s&id=%s&token=token",
+			strings.TrimPrefix(srv.URL, "http"),
+			opts.Key,
+			id,
+		)
+		dialer := websocket.Dialer{Subprotocols: protocols}
+		c, res, err := dialer.Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		assert.Equal(t, c.Subprotocol(), res.Header.Get("Sec-WebSocket-Protocol"))
+		msg := models.Message{}
+		assert.NoError(t, c.ReadJSON(&msg))
+		assert.Equal(t, MessageTypeOpen, msg.Type)
+		return c.Subprotocol()
+	}
+
+	assert.Equal(t, "json", dial("peer1", []string{"json"}))
+	assert.Equal(t, "peerjs", dial("peer2", []string{"json", "peerjs"}))
+	// unsupported subprotocols fall back to none
+	assert.Equal(t, "", dial("peer3", []string{"other"}))
+	assert.Equal(t, "", dial("peer4", nil))
+}
+
+func TestWebSocketServerRooms(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	wss, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	c1 := testDialWS(t, srv, opts.Key, "peer1", "token")
+	defer c1.Close()
+	c2 := testDialWS(t, srv, opts.Key, "peer2", "token")
+	defer c2.Close()
+	c3 := testDialWS(t, srv, opts.Key, "peer3", "token")
+	defer c3.Close()
+
+	assert.ErrorIs(t, wss.JoinRoom("unknown", "room"), ErrClientNotConnected)
+	assert.NoError(t, wss.JoinRoom("peer1", "room"))
+	assert.NoError(t, wss.JoinRoom("peer2", "room"))
+	assert.ElementsMatch(t, []string{"peer1", "peer2"}, wss.RoomMembers("room"))
+
+	received := func(c *websocket.Conn) string {
+		c.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+		_, raw, err := c.ReadMessage()
+		if err != nil {
+			return ""
+		}
+		return string(raw)
+	}
+
+	wss.SendToRoom("room", []byte("hello"))
+	assert.Equal(t, "hello", received(c1))
+	assert.Equal(t, "hello", received(c2))
+	assert.Equal(t, "", received(c3))
+
+	wss.LeaveRoom("peer2", "room")
+	wss.SendToRoom("room", []byte("bye"))
+	assert.Equal(t, "bye", received(c1))
+	assert.Equal(t, []string{"peer1"}, wss.RoomMembers("room"))
+
+	// members leave their rooms on disconnect
+	c1.Close()
+	assert.Eventually(t, func() bool {
+		return len(wss.RoomMembers("room")) == 0
+	}, time.Second, time.Millisecond*10)
+	wss.cMutex.Lock()
+	assert.Empty(t, wss.rooms)
+	wss.cMutex.Unlock()
+}
+
+func TestWebSocketServerTokenValidator(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	opts.TokenValidator = func(id, token string) (bool, error) {
+		if token == "broken" {
+			return false, errors.New("validator failure")
+		}
+		return token == "signed-"+id || token == "resigned-"+id, nil
+	}
+	_, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	dial := func(id, token string) (*websocket.Conn, models.Message) {
+		u := fmt.Sprintf(
+			"ws%s/peerjs?key=%s&id=%s&token=%s",
+			strings.TrimPrefix(srv.URL, "http"),
+			opts.Key,
+			id,
+			token,
+		)
+		c, _, err := websocket.DefaultDialer.Dial(u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := models.Message{}
+		assert.NoError(t, c.ReadJSON(&msg))
+		return c, msg
+	}
+
+	c, msg := dial("peer", "invalid")
+	c.Close()
+	assert.Equal(t, MessageTypeError, msg.Type)
+	assert.Equal(t, ErrorInvalidToken, msg.Payload.Msg)
+	assert.Equal(t, ErrorTypeInvalidToken, msg.Payload.Type)
+
+	c, msg = dial("peer", "broken")
+	c.Close()
+	assert.Equal(t, MessageTypeError, msg.Type)
+	assert.Equal(t, ErrorInvalidToken, msg.Payload.Msg)
+
+	c1, msg := dial("peer", "signed-peer")
+	defer c1.Close()
+	assert.Equal(t, MessageTypeOpen, msg.Type)
+
+	// a registered client must reconnect with the same token
+	c2, msg := dial("peer", "resigned-peer")
+	defer c2.Close()
+	assert.Equal(t, MessageTypeIDTaken, msg.Type)
+	c4, msg := dial("peer", "signed-peer")
+	defer c4.Close()
+	assert.Equal(t, MessageTypeOpen, msg.Type)
+
+	c3, msg := dial("peer", "signed-other")
+	defer c3.Close()
+	assert.Equal(t, MessageTypeIDTaken, msg.Type)
+}
+
+func TestWebSocketServerTokenGenerator(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	opts.AllowServerGeneratedIDs = true
+	opts.TokenGenerator = func(id string) (string, error) {
+		return "minted-" + id, nil
+	}
+	wss, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	u := fmt.Sprintf("ws%s/peerjs?key=%s&id=peer", strings.TrimPrefix(srv.URL, "http"), opts.Key)
+	c, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	msg := models.Message{}
+	assert.NoError(t, c.ReadJSON(&msg))
+	assert.Equal(t, MessageTypeOpen, msg.Type)
+	assert.Equal(t, "minted-peer", msg.Payload.Token)
+	assert.Equal(t, "minted-peer", wss.realm.GetClientByID("peer").GetToken())
+}
+
+func TestWebSocketServerWriteTimeout(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	opts.WriteTimeout = 100
+	wss, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	// never reads, filling its buffers until the writes time out
+	slow := testDialWS(t, srv, opts.Key, "slow", "token")
+	defer slow.Close()
+	reader := testDialWS(t, srv, opts.Key, "reader", "token")
+	defer reader.Close()
+
+	received := make(chan bool, 10)
+	go func() {
+		for {
+			if _, _, err := reader.ReadMessage(); err != nil {
+				return
+			}
+			received <- true
+		}
+	}()
+
+	data := make([]byte, 1024*1024)
+	for i := 0; i < 10; i++ {
+		wss.Send(data)
+	}
+
+	assert.Eventually(t, func() bool {
+		return wss.realm.GetClientByID("slow") == nil
+	}, time.Second*5, time.Millisecond*10)
+	assert.Equal(t, 1, testCountConns(wss))
+	assert.NotNil(t, wss.realm.GetClientByID("reader"))
+
+	for i := 0; i < 10; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("reader received %d messages", i)
+		}
+	}
+}
+
+func TestWebSocketServerSendTo(t *testing.T) {
+	opts := NewOptions()
+	opts.LogLevel = "error"
+	wss, srv := testStartWSS(opts)
+	defer srv.Close()
+
+	err := wss.SendTo("offline", &models.Message{Type: "NOTIFY"})
+	assert.ErrorIs(t, err, ErrClientNotConnected)
+
+	c := testDialWS(t, srv, opts.Key, "client", "token")
+	defer c.Close()
+
+	err = wss.SendTo("client", &models.Message{Type: "NOTIFY", Payload: models.Payload{Msg: "hello"}})
+	assert.NoError(t, err)
+
+	msg := models.Message{}
+	c.SetReadDeadline(time.Now().Add(time.Second * 2))
+	err = c.ReadJSON(&msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "NOTIFY", msg.Type)
+	assert.Equal(t, "hello", msg.Payload.Msg)
+}
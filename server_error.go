@@ -0,0 +1,90 @@
+package peer
+
+import (
+	"errors"
+
+	"github.com/muka/peerjs-go/enums"
+	"github.com/muka/peerjs-go/models"
+)
+
+var (
+	// ErrInvalidWSParameters the server rejected the connection missing id, token or key
+	ErrInvalidWSParameters = errors.New("invalid websocket parameters")
+	// ErrInvalidKey the server rejected the API key
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrInvalidToken the server rejected the token
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrInvalidID the server rejected the peer id
+	ErrInvalidID = errors.New("invalid id")
+	// ErrConnectionLimit the server reached its concurrent clients limit
+	ErrConnectionLimit = errors.New("server connection limit exceeded")
+	// ErrIPConnectionLimit the server reached the connections limit of the client address
+	ErrIPConnectionLimit = errors.New("address connection limit exceeded")
+	// ErrRateLimit the client exceeded the server message rate limit
+	ErrRateLimit = errors.New("message rate limit exceeded")
+	// ErrUnauthorized the server authentication rejected the client
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrServer an internal or unknown server error
+	ErrServer = errors.New("server error")
+)
+
+// serverErrorsByType map the error types sent by the server
+var serverErrorsByType = map[string]error{
+	enums.ServerErrorTypeInvalidWSParameters: ErrInvalidWSParameters,
+	enums.ServerErrorTypeInvalidKey:          ErrInvalidKey,
+	enums.ServerErrorTypeInvalidToken:        ErrInvalidToken,
+	enums.ServerErrorTypeInvalidID:           ErrInvalidID,
+	enums.ServerErrorTypeConnectionLimit:     ErrConnectionLimit,
+	enums.ServerErrorTypeIPConnectionLimit:   ErrIPConnectionLimit,
+	enums.ServerErrorTypeRateLimit:           ErrRateLimit,
+	enums.ServerErrorTypeServerError:         ErrServer,
+	enums.ServerErrorTypeUnauthorized:        ErrUnauthorized,
+}
+
+// serverErrorsByMsg map the messages of servers not sending the error type,
+// eg. the PeerJS server
+var serverErrorsByMsg = map[string]error{
+	"No id, token, or key supplied to websocket server": ErrInvalidWSParameters,
+	"Invalid key provided":                              ErrInvalidKey,
+	"Invalid token provided":                            ErrInvalidToken,
+	"Invalid id provided":                               ErrInvalidID,
+	"Server has reached its concurrent user limit":      ErrConnectionLimit,
+}
+
+// ServerError is an ERROR message received from the server. Use errors.Is
+// with the ErrX values to check the cause
+type ServerError struct {
+	// Type the error type sent by the server, if any
+	Type string
+	// Msg the error message sent by the server
+	Msg string
+	// Err the ErrX value matching the error, ErrServer if unknown
+	Err error
+}
+
+func (e ServerError) Unwrap() error { return e.Err }
+func (e ServerError) Error() string { return e.Msg }
+
+// PeerErrorType return the PeerError type matching the error
+func (e ServerError) PeerErrorType() string {
+	switch e.Err {
+	case ErrInvalidKey:
+		return enums.PeerErrorTypeInvalidKey
+	case ErrInvalidID:
+		return enums.PeerErrorTypeInvalidID
+	}
+	return enums.PeerErrorTypeServerError
+}
+
+// newServerError map an ERROR message to its ServerError
+func newServerError(msg models.Message) ServerError {
+	payload := msg.GetPayload()
+	err, ok := serverErrorsByType[payload.Type]
+	if !ok {
+		err, ok = serverErrorsByMsg[payload.Msg]
+	}
+	if !ok {
+		err = ErrServer
+	}
+	return ServerError{Type: payload.Type, Msg: payload.Msg, Err: err}
+}
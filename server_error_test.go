@@ -0,0 +1,111 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/muka/peerjs-go/enums"
+	"github.com/muka/peerjs-go/models"
+	"github.com/muka/peerjs-go/server"
+	"github.com/stretchr/testify/assert"
+)
+
+// startErrorServer reply to websocket clients with an ERROR message
+func startErrorServer(payload models.Payload) (*httptest.Server, Options) {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.WriteJSON(models.Message{Type: enums.ServerMessageTypeError, Payload: payload})
+		c.ReadMessage()
+	}))
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	opts := NewOptions()
+	opts.Host = u.Hostname()
+	opts.Port = port
+	opts.Secure = false
+	opts.PingInterval = 60000
+	return srv, opts
+}
+
+func TestServerErrors(t *testing.T) {
+	tests := []struct {
+		payload   models.Payload
+		expected  error
+		errorType string
+	}{
+		{models.Payload{Type: server.ErrorTypeInvalidWSParameters, Msg: server.ErrorInvalidWSParameters}, ErrInvalidWSParameters, enums.PeerErrorTypeServerError},
+		{models.Payload{Type: server.ErrorTypeInvalidKey, Msg: server.ErrorInvalidKey}, ErrInvalidKey, enums.PeerErrorTypeInvalidKey},
+		{models.Payload{Type: server.ErrorTypeInvalidToken, Msg: server.ErrorInvalidToken}, ErrInvalidToken, enums.PeerErrorTypeServerError},
+		{models.Payload{Type: server.ErrorTypeInvalidID, Msg: server.ErrorInvalidID}, ErrInvalidID, enums.PeerErrorTypeInvalidID},
+		{models.Payload{Type: server.ErrorTypeConnectionLimit, Msg: server.ErrorConnectionLimitExceeded}, ErrConnectionLimit, enums.PeerErrorTypeServerError},
+		{models.Payload{Type: server.ErrorTypeIPConnectionLimit, Msg: server.ErrorIPConnectionLimitExceeded}, ErrIPConnectionLimit, enums.PeerErrorTypeServerError},
+		{models.Payload{Type: server.ErrorTypeRateLimit, Msg: server.ErrorRateLimitExceeded}, ErrRateLimit, enums.PeerErrorTypeServerError},
+		{models.Payload{Type: server.ErrorTypeServerError, Msg: server.ErrorIDGenerationFailed}, ErrServer, enums.PeerErrorTypeServerError},
+		{models.Payload{Type: server.ErrorTypeUnauthorized, Msg: "Expired token"}, ErrUnauthorized, enums.PeerErrorTypeServerError},
+		// servers not sending the error type
+		{models.Payload{Msg: server.ErrorInvalidKey}, ErrInvalidKey, enums.PeerErrorTypeInvalidKey},
+		{models.Payload{Msg: "Unknown failure"}, ErrServer, enums.PeerErrorTypeServerError},
+	}
+
+	for _, test := range tests {
+		srv, opts := startErrorServer(test.payload)
+
+		s := NewSocket(opts)
+		events := make(chan error, 1)
+		s.OnMessage(func(ev SocketEvent) {
+			events <- ev.Error
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+		err := s.StartAndWait(ctx, "test", "test")
+		cancel()
+		srv.Close()
+
+		assert.ErrorIs(t, err, test.expected, test.payload.Msg)
+		peerErr := PeerError{}
+		assert.True(t, errors.As(err, &peerErr))
+		assert.Equal(t, test.errorType, peerErr.Type)
+		assert.Equal(t, test.payload.Msg, err.Error())
+
+		serverErr := ServerError{}
+		assert.True(t, errors.As(<-events, &serverErr))
+		assert.Equal(t, test.payload.Type, serverErr.Type)
+		assert.ErrorIs(t, serverErr, test.expected)
+	}
+}
+
+func TestServerErrorInvalidKey(t *testing.T) {
+	peerServer, serverOpts := startServer()
+	assert.NoError(t, peerServer.Start())
+	defer peerServer.Stop()
+
+	opts := getTestOpts(serverOpts)
+	opts.Key = "wrong"
+	peerErrors := make(chan error, 1)
+	p, err := NewPeer(rndName("peer"), opts)
+	assert.NoError(t, err)
+	defer p.Close()
+	p.On(enums.PeerEventTypeError, func(data interface{}) {
+		peerErrors <- data.(error)
+	})
+
+	select {
+	case err := <-peerErrors:
+		assert.ErrorIs(t, err, ErrInvalidKey)
+		assert.Equal(t, enums.PeerErrorTypeInvalidKey, err.(PeerError).Type)
+	case <-time.After(time.Second * 2):
+		t.Fatal("error not emitted")
+	}
+}
@@ -1,7 +1,9 @@
 package peer
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
@@ -12,21 +14,60 @@ import (
 	"github.com/muka/peerjs-go/emitter"
 	"github.com/muka/peerjs-go/enums"
 	"github.com/muka/peerjs-go/models"
-	"github.com/sirupsen/logrus"
+	"github.com/muka/peerjs-go/util"
 )
 
+// ErrSocketQueueFull is returned by Send when the connection is not available
+// and MaxQueueSize messages are already waiting to be sent
+var ErrSocketQueueFull = errors.New("socket send queue is full")
+
+// ErrSocketClosed is returned by Send when the connection is not available
+// and the message can not be queued, as the socket is closed or queuing is
+// disabled
+var ErrSocketClosed = errors.New("socket is closed")
+
+// ErrHeartbeatTimeout is the error of the disconnected event emitted when the
+// server stops replying to heartbeats
+var ErrHeartbeatTimeout = errors.New("server heartbeat reply timeout")
+
+// maxReconnectDelay caps the exponential backoff between reconnection attempts
+const maxReconnectDelay = time.Second * 30
+
+// MaxMissedHeartbeats number of ping intervals without a heartbeat reply after
+// which the connection is considered lost. Servers which never reply to
+// heartbeats are not checked
+const MaxMissedHeartbeats = 3
+
+// MaxReadErrors consecutive read errors after which the connection is
+// considered lost
+const MaxReadErrors = 3
+
+// wsConn is the websocket connection read by the socket
+type wsConn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	Close() error
+}
+
+// DefaultPingInterval heartbeat interval in ms used when PingInterval is unset
+const DefaultPingInterval = 5000
+
 // SocketEvent carries an event from the socket
 type SocketEvent struct {
 	Type    string
 	Message *models.Message
 	Error   error
+	// Attempt is the reconnection attempt number, set on reconnecting events
+	Attempt int
 }
 
 // NewSocket create a socket instance
 func NewSocket(opts Options) *Socket {
 	s := &Socket{
 		Emitter: emitter.NewEmitter(),
-		log:     createLogger("socket", opts.Debug),
+		log:     createLogger("socket", opts.Debug, opts.Logger),
+	}
+	if opts.PingInterval <= 0 {
+		opts.PingInterval = DefaultPingInterval
 	}
 	s.opts = opts
 	return s
@@ -36,12 +77,28 @@ func NewSocket(opts Options) *Socket {
 type Socket struct {
 	emitter.Emitter
 	id          string
+	token       string
 	opts        Options
 	baseURL     string
 	conn        *websocket.Conn
-	log         *logrus.Entry
+	log         Logger
 	mutex       sync.Mutex
 	wsPingTimer *time.Timer
+	closed      bool
+	ready       chan error
+	queue       [][]byte
+	// heartbeat round trip tracking
+	heartbeatSeq       int64
+	heartbeatSentAt    time.Time
+	heartbeatRepliedAt time.Time
+	lastRTT            time.Duration
+	// disconnectErr overrides the read error once the connection is dropped
+	disconnectErr error
+	// disconnectEmitted is set once the disconnected event of the current
+	// connection has been emitted
+	disconnectEmitted bool
+	// done is closed once the socket is closed, stopping the reconnection
+	done chan struct{}
 }
 
 func (s *Socket) buildBaseURL() string {
@@ -51,10 +108,7 @@ func (s *Socket) buildBaseURL() string {
 	}
 	port := strconv.Itoa(s.opts.Port)
 
-	path := s.opts.Path
-	if path == "/" {
-		path = ""
-	}
+	path := util.NormalizePath(s.opts.Path)
 
 	return fmt.Sprintf(
 		"%s://%s:%s%s/peerjs?key=%s",
@@ -67,19 +121,60 @@ func (s *Socket) buildBaseURL() string {
 }
 
 func (s *Socket) scheduleHeartbeat() {
+	if s.opts.DisableHeartbeat {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
 	s.wsPingTimer = time.AfterFunc(time.Millisecond*time.Duration(s.opts.PingInterval), func() {
 		s.sendHeartbeat()
 	})
 }
 
+// stopHeartbeat cancel the pending heartbeat, if any
+func (s *Socket) stopHeartbeat() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.wsPingTimer != nil {
+		s.wsPingTimer.Stop()
+		s.wsPingTimer = nil
+	}
+}
+
 func (s *Socket) sendHeartbeat() {
-	if s.conn == nil {
-		s.log.Debug(`Cannot send heartbeat, because socket closed`)
+	s.mutex.Lock()
+	conn := s.conn
+	if conn == nil {
+		closed := s.closed
+		s.mutex.Unlock()
+		if !closed {
+			s.log.Debug(`Cannot send heartbeat, because socket closed`)
+		}
+		return
+	}
+	now := time.Now()
+	timeout := time.Millisecond * time.Duration(s.opts.PingInterval*MaxMissedHeartbeats)
+	if !s.heartbeatRepliedAt.IsZero() && now.Sub(s.heartbeatRepliedAt) > timeout {
+		s.disconnectErr = ErrHeartbeatTimeout
+		s.mutex.Unlock()
+		s.log.Warnf("No heartbeat reply since %s, closing connection", s.heartbeatRepliedAt)
+		// the read go routine handles the disconnection
+		conn.Close()
 		return
 	}
+	s.heartbeatSeq++
+	s.heartbeatSentAt = now
+	seq := s.heartbeatSeq
+	s.mutex.Unlock()
 
 	msg := models.Message{
 		Type: enums.ServerMessageTypeHeartbeat,
+		Payload: models.Payload{
+			Msg: strconv.FormatInt(seq, 10),
+		},
 	}
 
 	res, err := json.Marshal(msg)
@@ -89,6 +184,10 @@ func (s *Socket) sendHeartbeat() {
 
 	// s.log.Debug("Send heartbeat")
 	err = s.Send(res)
+	if errors.Is(err, ErrSocketClosed) {
+		s.log.Debug(`Cannot send heartbeat, because socket closed`)
+		return
+	}
 	if err != nil {
 		s.log.Errorf("sendHeartbeat: Failed to send message: %s", err)
 		return
@@ -97,85 +196,374 @@ func (s *Socket) sendHeartbeat() {
 	s.scheduleHeartbeat()
 }
 
+// onHeartbeatReply record the round trip time of the last heartbeat
+func (s *Socket) onHeartbeatReply(msg models.Message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	s.heartbeatRepliedAt = now
+	if msg.Payload.Msg == strconv.FormatInt(s.heartbeatSeq, 10) {
+		s.lastRTT = now.Sub(s.heartbeatSentAt)
+	}
+}
+
+// onOpen store the id and token generated by the server, used to reconnect
+func (s *Socket) onOpen(msg models.Message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if msg.Payload.ID != "" {
+		s.id = msg.Payload.ID
+	}
+	if msg.Payload.Token != "" {
+		s.token = msg.Payload.Token
+	}
+}
+
+// onIDTaken stop the heartbeat and the reconnection attempts, as the server
+// closes the connection after rejecting the id
+func (s *Socket) onIDTaken() {
+	s.mutex.Lock()
+	s.log.Warnf("ID %s is taken", s.id)
+	s.markClosed()
+	s.mutex.Unlock()
+	s.stopHeartbeat()
+}
+
+// LastRTT return the round trip time of the last heartbeat replied by the
+// server, zero if none has been replied yet
+func (s *Socket) LastRTT() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastRTT
+}
+
+// takeDisconnectErr return and clear the disconnection cause
+func (s *Socket) takeDisconnectErr() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	err := s.disconnectErr
+	s.disconnectErr = nil
+	return err
+}
+
 // Start initiate the connection
 func (s *Socket) Start(id string, token string) error {
+	return s.StartContext(context.Background(), id, token)
+}
 
-	if s.conn != nil {
-		return nil
+// StartContext initiate the connection, aborting the dial if ctx is done
+// before the connection is established. Like StartAndWait, ctx does not bound
+// the lifetime of the connection once established.
+func (s *Socket) StartContext(ctx context.Context, id string, token string) error {
+	_, _, err := s.connect(ctx, id, token)
+	return err
+}
+
+// newDialer creates a websocket dialer from Options.Dialer, or the default
+// dialer, with the handshake timeout derived from the ctx deadline unless
+// the dialer sets one
+func (s *Socket) newDialer(ctx context.Context) *websocket.Dialer {
+	dialer := *websocket.DefaultDialer
+	if s.opts.Dialer != nil {
+		dialer = *s.opts.Dialer
+	}
+	if deadline, ok := ctx.Deadline(); ok && (s.opts.Dialer == nil || dialer.HandshakeTimeout == 0) {
+		dialer.HandshakeTimeout = time.Until(deadline)
 	}
+	if s.opts.Secure && s.opts.TLSConfig != nil && dialer.TLSClientConfig == nil {
+		dialer.TLSClientConfig = s.opts.TLSConfig
+	}
+	return &dialer
+}
 
+// connect dial the server and start the read go routine, the returned channel
+// is closed once the read go routine exits. A nil conn is returned if the
+// socket is already connected
+func (s *Socket) connect(ctx context.Context, id string, token string) (*websocket.Conn, chan struct{}, error) {
+
+	s.mutex.Lock()
+	if s.conn != nil {
+		s.mutex.Unlock()
+		return nil, nil, nil
+	}
+	s.id = id
+	s.token = token
+	if s.closed || s.done == nil {
+		s.done = make(chan struct{})
+	}
+	s.closed = false
 	if s.baseURL == "" {
 		s.baseURL = s.buildBaseURL()
 	}
+	baseURL := s.baseURL
+	s.mutex.Unlock()
 
-	url := s.baseURL + fmt.Sprintf("&id=%s&token=%s", id, token)
+	url := baseURL + fmt.Sprintf("&id=%s&token=%s", id, token)
 	s.log.Debugf("Connecting to %s", url)
-	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+	c, _, err := s.newDialer(ctx).DialContext(ctx, url, nil)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
+
+	s.mutex.Lock()
 	s.conn = c
+	s.heartbeatRepliedAt = time.Time{}
+	s.disconnectErr = nil
+	s.disconnectEmitted = false
+	s.flushQueue()
+	s.mutex.Unlock()
 
-	s.conn.SetCloseHandler(func(code int, text string) error {
+	c.SetCloseHandler(func(code int, text string) error {
 		s.log.Debug("WS closed")
-		s.conn = nil
+		s.mutex.Lock()
+		if s.conn == c {
+			s.conn = nil
+		}
+		s.mutex.Unlock()
 		return nil
 	})
 
 	// ws ping by sending heartbeat message
 	s.scheduleHeartbeat()
 
+	done := make(chan struct{})
+
 	// collect messages
-	go func() {
-		for {
+	go s.readLoop(c, done)
 
-			if s.conn == nil {
-				s.log.Debug("WS connection unset, closing read go routine")
+	return c, done, nil
+}
+
+// readLoop read the messages received on conn until the connection is lost,
+// closing done on exit
+func (s *Socket) readLoop(conn wsConn, done chan struct{}) {
+	defer close(done)
+	readErrors := 0
+	for {
+		msgType, raw, err := conn.ReadMessage()
+		s.log.Debugf("WS msg %v", msgType)
+		if err != nil {
+			// catch close error, avoid panic reading a closed conn
+			if _, ok := err.(*websocket.CloseError); ok {
+				s.log.Debugf("websocket closed: %s", err)
+				s.onDisconnected(conn, err)
 				return
 			}
+			var opErr *net.OpError
+			if errors.Is(err, net.ErrClosed) || errors.As(err, &opErr) {
+				s.log.Debugf("websocket closed: %s", err)
+				if disconnectErr := s.takeDisconnectErr(); disconnectErr != nil {
+					err = disconnectErr
+				}
+				s.onDisconnected(conn, err)
+				return
+			}
+			// a broken connection keeps returning the same error
+			readErrors++
+			if readErrors >= MaxReadErrors {
+				s.log.Warnf("websocket read failed %d times, closing connection: %s", readErrors, err)
+				s.onDisconnected(conn, err)
+				return
+			}
+			s.log.Warnf("websocket read error: %s", err)
+			continue
+		}
+		readErrors = 0
+
+		s.log.Infof("websocket message: %s", raw)
 
-			msgType, raw, err := s.conn.ReadMessage()
-			s.log.Debugf("WS msg %v", msgType)
+		if msgType == websocket.TextMessage {
+
+			msg := models.Message{}
+			err = json.Unmarshal(raw, &msg)
 			if err != nil {
-				// catch close error, avoid panic reading a closed conn
-				if _, ok := err.(*websocket.CloseError); ok {
-					s.log.Debugf("websocket closed: %s", err)
-					s.Emit(enums.SocketEventTypeDisconnected, SocketEvent{enums.SocketEventTypeDisconnected, nil, err})
-					return
-				} else if opErr, ok := err.(*net.OpError); ok {
-					s.log.Debugf("websocket closed: %s OpErr Op %s", opErr, opErr.Op)
-					s.Emit(enums.SocketEventTypeDisconnected, SocketEvent{enums.SocketEventTypeDisconnected, nil, err})
-					return
-				}
-				s.log.Warnf("websocket read error: %s", err)
+				s.log.Errorf("Failed to decode websocket message=%s %s", string(raw), err)
+			} else if msg.Type == enums.ServerMessageTypeError {
+				err = newServerError(msg)
+			}
+
+			if msg.Type == enums.ServerMessageTypeHeartbeat {
+				s.onHeartbeatReply(msg)
 				continue
 			}
+			if msg.Type == enums.ServerMessageTypeOpen {
+				s.onOpen(msg)
+			}
+			if msg.Type == enums.ServerMessageTypeIDTaken {
+				s.onIDTaken()
+			}
 
-			s.log.Infof("websocket message: %s", raw)
+			s.Emit(enums.SocketEventTypeMessage, SocketEvent{Type: enums.SocketEventTypeMessage, Message: &msg, Error: err})
+			s.signalReady(msg)
+		} else {
+			s.log.Warnf("Unmanaged websocket message type %d", msgType)
+		}
 
-			if msgType == websocket.TextMessage {
+	}
+}
 
-				msg := models.Message{}
-				err = json.Unmarshal(raw, &msg)
-				if err != nil {
-					s.log.Errorf("Failed to decode websocket message=%s %s", string(raw), err)
-				}
+// StartAndWait initiate the connection and blocks until the server accepts it
+// with an OPEN message. If the server rejects the client or ctx is done before
+// the connection is open, the socket is closed and an error is returned. ctx
+// does not bound the lifetime of the connection once open.
+func (s *Socket) StartAndWait(ctx context.Context, id string, token string) error {
+	ready := make(chan error, 1)
+	s.mutex.Lock()
+	s.ready = ready
+	s.mutex.Unlock()
 
-				s.Emit(enums.SocketEventTypeMessage, SocketEvent{enums.SocketEventTypeMessage, &msg, err})
-			} else {
-				s.log.Warnf("Unmanaged websocket message type %d", msgType)
-			}
+	c, _, err := s.connect(ctx, id, token)
+	if err == nil && c == nil {
+		// already connected
+		s.mutex.Lock()
+		s.ready = nil
+		s.mutex.Unlock()
+		return nil
+	}
+	if err == nil {
+		select {
+		case err = <-ready:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	s.mutex.Lock()
+	s.ready = nil
+	s.mutex.Unlock()
+
+	if err != nil {
+		s.Close()
+	}
+	return err
+}
 
+// signalReady notifies StartAndWait of the server response to the connection
+func (s *Socket) signalReady(msg models.Message) {
+	var err error
+	switch msg.GetType() {
+	case enums.ServerMessageTypeOpen:
+	case enums.ServerMessageTypeError:
+		serverErr := newServerError(msg)
+		err = PeerError{Type: serverErr.PeerErrorType(), Err: serverErr}
+	case enums.ServerMessageTypeIDTaken:
+		s.mutex.Lock()
+		id := s.id
+		s.mutex.Unlock()
+		err = PeerError{Type: enums.PeerErrorTypeUnavailableID, Err: fmt.Errorf("ID %s is taken", id)}
+	case enums.ServerMessageTypeInvalidKey:
+		err = PeerError{Type: enums.PeerErrorTypeInvalidKey, Err: fmt.Errorf("API KEY %s is invalid", s.opts.Key)}
+	default:
+		return
+	}
+	s.notifyReady(err)
+}
+
+func (s *Socket) notifyReady(err error) {
+	s.mutex.Lock()
+	ready := s.ready
+	s.ready = nil
+	s.mutex.Unlock()
+	if ready != nil {
+		ready <- err
+	}
+}
+
+// onDisconnected handles a lost connection, retrying if Reconnect is enabled
+func (s *Socket) onDisconnected(conn wsConn, err error) {
+	s.notifyReady(PeerError{Type: enums.PeerErrorTypeNetwork, Err: err})
+	s.mutex.Lock()
+	if s.conn == conn {
+		s.conn = nil
+	}
+	closed := s.closed
+	s.mutex.Unlock()
+	conn.Close()
+	if s.opts.Reconnect && !closed {
+		s.stopHeartbeat()
+		if s.reconnect() {
+			return
 		}
-	}()
+	}
+	s.emitDisconnected(err)
+}
 
-	return nil
+// emitDisconnected emit the disconnected event, once per connection
+func (s *Socket) emitDisconnected(err error) {
+	s.mutex.Lock()
+	if s.disconnectEmitted {
+		s.mutex.Unlock()
+		return
+	}
+	s.disconnectEmitted = true
+	s.mutex.Unlock()
+	s.Emit(enums.SocketEventTypeDisconnected, SocketEvent{Type: enums.SocketEventTypeDisconnected, Error: err})
 }
 
-// Close close the websocket connection
+// reconnect dial again the server with the stored id and token, doubling the
+// delay after each failure. Returns true once the connection is restored
+func (s *Socket) reconnect() bool {
+	delay := s.opts.ReconnectBaseDelay
+	for attempt := 1; attempt <= s.opts.ReconnectMaxAttempts; attempt++ {
+		s.Emit(enums.SocketEventTypeReconnecting, SocketEvent{Type: enums.SocketEventTypeReconnecting, Attempt: attempt})
+		s.mutex.Lock()
+		done, id, token := s.done, s.id, s.token
+		s.mutex.Unlock()
+		select {
+		case <-done:
+			return false
+		case <-time.After(delay):
+		}
+		err := s.Start(id, token)
+		if err == nil {
+			s.log.Debugf("Reconnected after %d attempts", attempt)
+			return true
+		}
+		s.log.Debugf("Reconnect attempt %d failed: %s", attempt, err)
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+	s.log.Warnf("Giving up reconnection after %d attempts", s.opts.ReconnectMaxAttempts)
+	return false
+}
+
+// Close close the websocket connection, emitting the disconnected event with
+// a nil error if the socket was connected. Closing the connection unblocks the
+// read go routine, which exits without emitting the event again. Closing a
+// closed socket is a no-op
 func (s *Socket) Close() error {
+	connected, err := s.close()
+	if connected {
+		s.Emit(enums.SocketEventTypeDisconnected, SocketEvent{Type: enums.SocketEventTypeDisconnected})
+	}
+	return err
+}
+
+// markClosed flag the socket as closed, stopping the reconnection attempts.
+// Must be called holding the mutex
+func (s *Socket) markClosed() {
+	if !s.closed && s.done != nil {
+		close(s.done)
+	}
+	s.closed = true
+}
+
+// close close the connection, if any, returning true if it was connected and
+// its disconnected event has still to be emitted
+func (s *Socket) close() (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.markClosed()
+	// a heartbeat firing meanwhile finds the socket closed and does not
+	// reschedule itself
+	if s.wsPingTimer != nil {
+		s.wsPingTimer.Stop()
+		s.wsPingTimer = nil
+	}
 	if s.conn == nil {
-		return nil
+		return false, nil
 	}
 	err := s.conn.WriteMessage(
 		websocket.CloseMessage,
@@ -190,15 +578,51 @@ func (s *Socket) Close() error {
 	}
 	s.log.Debug("Closed websocket")
 	s.conn = nil
-	return err
+	connected := !s.disconnectEmitted
+	s.disconnectEmitted = true
+	return connected, err
 }
 
-// Send send a message
+// flushQueue send the messages queued while disconnected, must be called
+// holding the mutex
+func (s *Socket) flushQueue() {
+	for len(s.queue) > 0 {
+		err := s.conn.WriteMessage(websocket.TextMessage, s.queue[0])
+		if err != nil {
+			s.log.Warnf("Failed to send queued message: %s", err)
+			return
+		}
+		s.queue = s.queue[1:]
+	}
+	s.queue = nil
+}
+
+// Send send a message. While the connection is not available messages are
+// queued, up to MaxQueueSize, and sent once connected. ErrSocketClosed is
+// returned if the socket is closed or queuing is disabled
 func (s *Socket) Send(msg []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	if s.conn == nil {
+		if s.closed || s.opts.MaxQueueSize <= 0 {
+			return ErrSocketClosed
+		}
+		if len(s.queue) >= s.opts.MaxQueueSize {
+			return ErrSocketQueueFull
+		}
+		s.queue = append(s.queue, msg)
 		return nil
 	}
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
 	return s.conn.WriteMessage(websocket.TextMessage, msg)
 }
+
+// OnMessage register a handler for the messages received from the server
+func (s *Socket) OnMessage(handler func(SocketEvent)) {
+	emitter.OnTyped(s, enums.SocketEventTypeMessage, handler)
+}
+
+// OnDisconnected register a handler called when the connection to the server
+// is lost
+func (s *Socket) OnDisconnected(handler func(SocketEvent)) {
+	emitter.OnTyped(s, enums.SocketEventTypeDisconnected, handler)
+}
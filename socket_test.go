@@ -1,11 +1,25 @@
 package peer
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/muka/peerjs-go/enums"
+	"github.com/muka/peerjs-go/models"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -28,3 +42,745 @@ func TestNewSocket(t *testing.T) {
 	<-time.After(time.Millisecond * 500)
 	assert.True(t, done)
 }
+
+func TestSocketStartAndWait(t *testing.T) {
+	srv, srvOpts := startServer()
+	srv.Start()
+	defer srv.Stop()
+	s := NewSocket(getTestOpts(srvOpts))
+	opened := make(chan bool, 1)
+	s.On(enums.SocketEventTypeMessage, func(data interface{}) {
+		ev := data.(SocketEvent)
+		if ev.Message != nil && ev.Message.Type == enums.ServerMessageTypeOpen {
+			opened <- true
+		}
+	})
+	err := s.StartAndWait(context.Background(), "test", "test")
+	assert.NoError(t, err)
+	// the OPEN message is received before StartAndWait returns
+	assert.Len(t, opened, 1)
+	err = s.Close()
+	assert.NoError(t, err)
+}
+
+func TestSocketStartAndWaitIDTaken(t *testing.T) {
+	srv, srvOpts := startServer()
+	srv.Start()
+	defer srv.Stop()
+
+	s1 := NewSocket(getTestOpts(srvOpts))
+	err := s1.StartAndWait(context.Background(), "taken", "token1")
+	assert.NoError(t, err)
+	defer s1.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	s2 := NewSocket(getTestOpts(srvOpts))
+	err = s2.StartAndWait(ctx, "taken", "token2")
+	assert.Error(t, err)
+	peerErr, ok := err.(PeerError)
+	assert.True(t, ok)
+	assert.Equal(t, enums.PeerErrorTypeUnavailableID, peerErr.Type)
+}
+
+func TestSocketStartAndWaitTwice(t *testing.T) {
+	srv, srvOpts := startServer()
+	srv.Start()
+	defer srv.Stop()
+
+	s := NewSocket(getTestOpts(srvOpts))
+	err := s.StartAndWait(context.Background(), "twice", "token")
+	assert.NoError(t, err)
+	defer s.Close()
+
+	started := make(chan error, 1)
+	go func() {
+		started <- s.StartAndWait(context.Background(), "twice", "token")
+	}()
+	select {
+	case err := <-started:
+		assert.NoError(t, err)
+	case <-time.After(time.Second * 2):
+		t.Fatal("StartAndWait blocked on a connected socket")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, s.StartAndWait(ctx, "twice", "token"))
+
+	// the connection is still open
+	s.mutex.Lock()
+	connected := s.conn != nil
+	s.mutex.Unlock()
+	assert.True(t, connected)
+}
+
+type testWSServer struct {
+	*httptest.Server
+	// conns receives the query of each accepted connection
+	conns chan url.Values
+	// messages receives the messages sent by clients
+	messages chan []byte
+	// closed is notified when a client connection is closed
+	closed chan bool
+}
+
+// startFlakyServer accept websocket clients, sending OPEN and dropping the
+// first `drop` connections right after
+func startFlakyServer(drop int) (*testWSServer, Options) {
+	upgrader := websocket.Upgrader{}
+	srv := &testWSServer{
+		conns:    make(chan url.Values, 10),
+		messages: make(chan []byte, 10),
+		closed:   make(chan bool, 10),
+	}
+	var count int32
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		srv.conns <- r.URL.Query()
+		c.WriteJSON(models.Message{Type: enums.ServerMessageTypeOpen})
+		if int(atomic.AddInt32(&count, 1)) <= drop {
+			c.Close()
+			return
+		}
+		for {
+			_, raw, err := c.ReadMessage()
+			if err != nil {
+				srv.closed <- true
+				return
+			}
+			srv.messages <- raw
+		}
+	}))
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	opts := NewOptions()
+	opts.Host = u.Hostname()
+	opts.Port = port
+	opts.Secure = false
+	opts.Debug = 3
+	opts.PingInterval = 60000
+	return srv, opts
+}
+
+func TestSocketReconnect(t *testing.T) {
+	srv, opts := startFlakyServer(1)
+	defer srv.Close()
+	opts.Reconnect = true
+	opts.ReconnectBaseDelay = time.Millisecond * 10
+
+	s := NewSocket(opts)
+	attempts := make(chan int, 10)
+	s.On(enums.SocketEventTypeReconnecting, func(data interface{}) {
+		attempts <- data.(SocketEvent).Attempt
+	})
+	err := s.Start("test", "secret")
+	assert.NoError(t, err)
+	defer s.Close()
+
+	q := <-srv.conns
+	assert.Equal(t, "test", q.Get("id"))
+
+	select {
+	case q = <-srv.conns:
+		assert.Equal(t, "test", q.Get("id"))
+		assert.Equal(t, "secret", q.Get("token"))
+	case <-time.After(time.Second * 2):
+		t.Fatal("socket did not reconnect")
+	}
+	assert.Equal(t, 1, <-attempts)
+}
+
+func TestSocketReconnectGiveUp(t *testing.T) {
+	srv, opts := startFlakyServer(1)
+	defer srv.Close()
+	opts.Reconnect = true
+	opts.ReconnectMaxAttempts = 3
+	opts.ReconnectBaseDelay = time.Millisecond * 100
+
+	s := NewSocket(opts)
+	attempts := make(chan int, 10)
+	s.On(enums.SocketEventTypeReconnecting, func(data interface{}) {
+		attempts <- data.(SocketEvent).Attempt
+	})
+	disconnected := make(chan bool, 1)
+	s.On(enums.SocketEventTypeDisconnected, func(data interface{}) {
+		disconnected <- true
+	})
+
+	err := s.Start("test", "secret")
+	assert.NoError(t, err)
+	defer s.Close()
+
+	// refuse any further connection
+	<-srv.conns
+	srv.Listener.Close()
+
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second * 3):
+		t.Fatal("socket did not give up reconnecting")
+	}
+	close(attempts)
+	seen := []int{}
+	for attempt := range attempts {
+		seen = append(seen, attempt)
+	}
+	assert.Equal(t, []int{1, 2, 3}, seen)
+}
+
+func TestSocketSendClosed(t *testing.T) {
+	opts := NewOptions()
+	opts.MaxQueueSize = 0
+	s := NewSocket(opts)
+	assert.ErrorIs(t, s.Send([]byte("message")), ErrSocketClosed)
+
+	// queued messages are dropped once closed
+	s = NewSocket(NewOptions())
+	assert.NoError(t, s.Send([]byte("message")))
+	assert.NoError(t, s.Close())
+	assert.ErrorIs(t, s.Send([]byte("message")), ErrSocketClosed)
+}
+
+func TestSocketQueueBeforeStart(t *testing.T) {
+	srv, opts := startFlakyServer(0)
+	defer srv.Close()
+	opts.MaxQueueSize = 2
+
+	s := NewSocket(opts)
+	assert.NoError(t, s.Send([]byte("first")))
+	assert.NoError(t, s.Send([]byte("second")))
+	assert.Equal(t, ErrSocketQueueFull, s.Send([]byte("third")))
+
+	err := s.Start("test", "test")
+	assert.NoError(t, err)
+	defer s.Close()
+
+	for _, expected := range []string{"first", "second"} {
+		select {
+		case raw := <-srv.messages:
+			assert.Equal(t, expected, string(raw))
+		case <-time.After(time.Second):
+			t.Fatalf("queued message %s not flushed", expected)
+		}
+	}
+}
+
+func TestSocketStartContextTimeout(t *testing.T) {
+	// accept TCP connections without ever completing the handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	opts := NewOptions()
+	opts.Host = "127.0.0.1"
+	opts.Port = l.Addr().(*net.TCPAddr).Port
+	opts.Secure = false
+	opts.Debug = 3
+
+	s := NewSocket(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	started := time.Now()
+	err = s.StartContext(ctx, "test", "test")
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(started)), int64(time.Second*2))
+}
+
+func TestSocketStartContextCancel(t *testing.T) {
+	srv, opts := startFlakyServer(0)
+	defer srv.Close()
+
+	s := NewSocket(opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	err := s.StartContext(ctx, "test", "test")
+	assert.NoError(t, err)
+	defer s.Close()
+	<-srv.conns
+
+	// ctx bounds only the dial, the connection stays open
+	cancel()
+	select {
+	case <-srv.closed:
+		t.Fatal("socket closed on context cancel")
+	case <-time.After(time.Millisecond * 200):
+	}
+	err = s.Send([]byte("after cancel"))
+	assert.NoError(t, err)
+	select {
+	case raw := <-srv.messages:
+		assert.Equal(t, "after cancel", string(raw))
+	case <-time.After(time.Second):
+		t.Fatal("message not received after context cancel")
+	}
+}
+
+func TestSocketTLSConfig(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.WriteJSON(models.Message{Type: enums.ServerMessageTypeOpen})
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	opts := NewOptions()
+	opts.Host = u.Hostname()
+	opts.Port = port
+	opts.Secure = true
+	opts.Debug = 3
+	opts.PingInterval = 60000
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	// the self-signed certificate is rejected by default
+	s := NewSocket(opts)
+	err := s.StartAndWait(ctx, "test", "test")
+	assert.Error(t, err)
+
+	opts.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	s = NewSocket(opts)
+	err = s.StartAndWait(ctx, "test", "test")
+	assert.NoError(t, err)
+	s.Close()
+}
+
+func TestSocketDialer(t *testing.T) {
+	srv, srvOpts := startServer()
+	srv.Start()
+	defer srv.Stop()
+
+	var dialed int32
+	opts := getTestOpts(srvOpts)
+	opts.Dialer = &websocket.Dialer{
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dialed, 1)
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}
+	s := NewSocket(opts)
+	err := s.StartAndWait(context.Background(), "test", "test")
+	assert.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dialed))
+}
+
+func TestSocketHeartbeatRTT(t *testing.T) {
+	srv, srvOpts := startServer()
+	srv.Start()
+	defer srv.Stop()
+
+	opts := getTestOpts(srvOpts)
+	opts.PingInterval = 50
+	s := NewSocket(opts)
+	err := s.StartAndWait(context.Background(), "test", "test")
+	assert.NoError(t, err)
+	defer s.Close()
+
+	assert.Equal(t, time.Duration(0), s.LastRTT())
+	assert.Eventually(t, func() bool {
+		return s.LastRTT() > 0
+	}, time.Second*2, time.Millisecond*10)
+}
+
+func TestSocketHeartbeatTimeout(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.WriteJSON(models.Message{Type: enums.ServerMessageTypeOpen})
+		// reply only to the first heartbeat
+		replied := false
+		for {
+			msg := models.Message{}
+			if err := c.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type == enums.ServerMessageTypeHeartbeat && !replied {
+				replied = true
+				c.WriteJSON(msg)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	opts := NewOptions()
+	opts.Host = u.Hostname()
+	opts.Port = port
+	opts.Secure = false
+	opts.Debug = 3
+	opts.PingInterval = 50
+
+	s := NewSocket(opts)
+	disconnected := make(chan error, 1)
+	s.OnDisconnected(func(ev SocketEvent) {
+		disconnected <- ev.Error
+	})
+	err := s.StartAndWait(context.Background(), "test", "test")
+	assert.NoError(t, err)
+	defer s.Close()
+
+	select {
+	case err := <-disconnected:
+		assert.Equal(t, ErrHeartbeatTimeout, err)
+	case <-time.After(time.Second * 2):
+		t.Fatal("heartbeat timeout not detected")
+	}
+	assert.Greater(t, int64(s.LastRTT()), int64(0))
+}
+
+// startHeartbeatServer starts a server counting the heartbeats received
+func startHeartbeatServer() (*httptest.Server, Options, *int32) {
+	heartbeats := new(int32)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.WriteJSON(models.Message{Type: enums.ServerMessageTypeOpen})
+		for {
+			msg := models.Message{}
+			if err := c.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type == enums.ServerMessageTypeHeartbeat {
+				atomic.AddInt32(heartbeats, 1)
+			}
+		}
+	}))
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	opts := NewOptions()
+	opts.Host = u.Hostname()
+	opts.Port = port
+	opts.Secure = false
+	opts.Debug = 3
+	opts.PingInterval = 20
+	return srv, opts, heartbeats
+}
+
+func TestSocketPingIntervalDefault(t *testing.T) {
+	opts := NewOptions()
+	opts.PingInterval = 0
+	s := NewSocket(opts)
+	assert.Equal(t, DefaultPingInterval, s.opts.PingInterval)
+}
+
+func TestSocketDisableHeartbeat(t *testing.T) {
+	srv, opts, heartbeats := startHeartbeatServer()
+	defer srv.Close()
+
+	opts.DisableHeartbeat = true
+	s := NewSocket(opts)
+	err := s.StartAndWait(context.Background(), "test", "test")
+	assert.NoError(t, err)
+	defer s.Close()
+
+	<-time.After(time.Millisecond * 200)
+	assert.Equal(t, int32(0), atomic.LoadInt32(heartbeats))
+}
+
+func TestSocketCloseStopsHeartbeat(t *testing.T) {
+	srv, opts, heartbeats := startHeartbeatServer()
+	defer srv.Close()
+
+	s := NewSocket(opts)
+	err := s.StartAndWait(context.Background(), "test", "test")
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(heartbeats) > 0
+	}, time.Second*2, time.Millisecond*10)
+
+	s.Close()
+	// let an in flight heartbeat reach the server
+	<-time.After(time.Millisecond * 50)
+	sent := atomic.LoadInt32(heartbeats)
+	<-time.After(time.Millisecond * 200)
+	assert.Equal(t, sent, atomic.LoadInt32(heartbeats))
+	s.mutex.Lock()
+	assert.Nil(t, s.wsPingTimer)
+	s.mutex.Unlock()
+}
+
+type testLogger struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) log(format string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Lines() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]string{}, l.lines...)
+}
+
+func (l *testLogger) Debug(args ...interface{})                 { l.log("%s", fmt.Sprint(args...)) }
+func (l *testLogger) Debugf(format string, args ...interface{}) { l.log(format, args...) }
+func (l *testLogger) Infof(format string, args ...interface{})  { l.log(format, args...) }
+func (l *testLogger) Warnf(format string, args ...interface{})  { l.log(format, args...) }
+func (l *testLogger) Errorf(format string, args ...interface{}) { l.log(format, args...) }
+
+func TestSocketLogger(t *testing.T) {
+	srv, opts := startFlakyServer(0)
+	defer srv.Close()
+	logger := &testLogger{}
+	opts.Logger = logger
+
+	s := NewSocket(opts)
+	err := s.Start("test", "test")
+	assert.NoError(t, err)
+	s.Close()
+
+	assert.Contains(t, logger.Lines(), fmt.Sprintf("Connecting to %s&id=test&token=test", s.baseURL))
+}
+
+func TestSocketNoHeartbeatAfterClose(t *testing.T) {
+	srv, opts, _ := startHeartbeatServer()
+	defer srv.Close()
+	logger := &testLogger{}
+	opts.Logger = logger
+	opts.PingInterval = 1
+
+	for i := 0; i < 50; i++ {
+		s := NewSocket(opts)
+		err := s.Start(fmt.Sprintf("test%d", i), "test")
+		assert.NoError(t, err)
+		<-time.After(time.Millisecond * time.Duration(i%3))
+		s.Close()
+	}
+	// wait for the timers scheduled before close to expire
+	<-time.After(time.Millisecond * 50)
+
+	for _, line := range logger.Lines() {
+		assert.NotContains(t, line, "Cannot send heartbeat")
+	}
+}
+
+func TestSocketCloseEmitsDisconnectedOnce(t *testing.T) {
+	countDisconnected := func(s *Socket) *int32 {
+		count := new(int32)
+		s.OnDisconnected(func(ev SocketEvent) {
+			atomic.AddInt32(count, 1)
+		})
+		return count
+	}
+
+	// client initiated, with concurrent Close calls
+	srv, opts := startFlakyServer(0)
+	defer srv.Close()
+	s := NewSocket(opts)
+	count := countDisconnected(s)
+	assert.NoError(t, s.Start("test", "test"))
+	<-srv.conns
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, s.Close())
+		}()
+	}
+	wg.Wait()
+	<-time.After(time.Millisecond * 200)
+	assert.Equal(t, int32(1), atomic.LoadInt32(count))
+
+	// server initiated, followed by Close
+	srv2, opts2 := startFlakyServer(1)
+	defer srv2.Close()
+	s2 := NewSocket(opts2)
+	count2 := countDisconnected(s2)
+	assert.NoError(t, s2.Start("test", "test"))
+	<-srv2.conns
+	<-time.After(time.Millisecond * 200)
+	assert.NoError(t, s2.Close())
+	<-time.After(time.Millisecond * 200)
+	assert.Equal(t, int32(1), atomic.LoadInt32(count2))
+}
+
+// faultyConn is a websocket connection failing every read with err
+type faultyConn struct {
+	err   error
+	reads int32
+}
+
+func (c *faultyConn) ReadMessage() (int, []byte, error) {
+	atomic.AddInt32(&c.reads, 1)
+	return 0, nil, c.err
+}
+
+func (c *faultyConn) Close() error {
+	return nil
+}
+
+func TestSocketReadErrors(t *testing.T) {
+	for _, readErr := range []error{io.ErrUnexpectedEOF, net.ErrClosed} {
+		s := NewSocket(NewOptions())
+		disconnected := make(chan error, 1)
+		s.OnDisconnected(func(ev SocketEvent) {
+			disconnected <- ev.Error
+		})
+
+		conn := &faultyConn{err: readErr}
+		done := make(chan struct{})
+		go s.readLoop(conn, done)
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("read loop did not exit on %s", readErr)
+		}
+		select {
+		case err := <-disconnected:
+			assert.ErrorIs(t, err, readErr)
+		case <-time.After(time.Second):
+			t.Fatalf("disconnected not emitted on %s", readErr)
+		}
+
+		expected := int32(MaxReadErrors)
+		if readErr == net.ErrClosed {
+			expected = 1
+		}
+		assert.Equal(t, expected, atomic.LoadInt32(&conn.reads))
+	}
+}
+
+func TestSocketIDTaken(t *testing.T) {
+	srv, srvOpts := startServer()
+	srv.Start()
+	defer srv.Stop()
+
+	s1 := NewSocket(getTestOpts(srvOpts))
+	assert.NoError(t, s1.StartAndWait(context.Background(), "taken", "token1"))
+	defer s1.Close()
+
+	opts := getTestOpts(srvOpts)
+	opts.PingInterval = 50
+	opts.Reconnect = true
+	opts.ReconnectBaseDelay = time.Millisecond * 10
+	s2 := NewSocket(opts)
+	idTaken := make(chan bool, 1)
+	s2.OnMessage(func(ev SocketEvent) {
+		if ev.Message.GetType() == enums.ServerMessageTypeIDTaken {
+			idTaken <- true
+		}
+	})
+	reconnecting := make(chan bool, 1)
+	s2.On(enums.SocketEventTypeReconnecting, func(data interface{}) {
+		reconnecting <- true
+	})
+	assert.NoError(t, s2.Start("taken", "token2"))
+	defer s2.Close()
+
+	select {
+	case <-idTaken:
+	case <-time.After(time.Second * 2):
+		t.Fatal("id taken not received")
+	}
+
+	s2.mutex.Lock()
+	assert.Nil(t, s2.wsPingTimer)
+	s2.mutex.Unlock()
+
+	select {
+	case <-reconnecting:
+		t.Fatal("socket reconnecting with a taken id")
+	case <-time.After(time.Millisecond * 300):
+	}
+}
+
+func TestSocketStartCloseNoLeak(t *testing.T) {
+	srv, opts, _ := startHeartbeatServer()
+	defer srv.Close()
+	opts.Debug = 0
+	opts.Reconnect = true
+
+	s := NewSocket(opts)
+	assert.NoError(t, s.Start("test", "test"))
+	assert.NoError(t, s.Close())
+	<-time.After(time.Millisecond * 100)
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, s.Start("test", "test"))
+		assert.NoError(t, s.Close())
+	}
+
+	// polling without assert.Eventually, which runs its own go routines
+	after := runtime.NumGoroutine()
+	for i := 0; i < 100 && after > before; i++ {
+		<-time.After(time.Millisecond * 20)
+		after = runtime.NumGoroutine()
+	}
+	assert.LessOrEqual(t, after, before, "go routines leaked")
+}
+
+func TestSocketCloseStopsReconnect(t *testing.T) {
+	srv, opts := startFlakyServer(1)
+	defer srv.Close()
+	opts.Reconnect = true
+	opts.ReconnectBaseDelay = time.Second * 10
+
+	s := NewSocket(opts)
+	reconnecting := make(chan bool, 1)
+	s.On(enums.SocketEventTypeReconnecting, func(data interface{}) {
+		reconnecting <- true
+	})
+	disconnected := make(chan bool, 1)
+	s.OnDisconnected(func(ev SocketEvent) {
+		disconnected <- true
+	})
+	assert.NoError(t, s.Start("test", "test"))
+
+	select {
+	case <-reconnecting:
+	case <-time.After(time.Second * 2):
+		t.Fatal("socket not reconnecting")
+	}
+	assert.NoError(t, s.Close())
+
+	// the reconnection delay is interrupted
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("reconnection not stopped")
+	}
+}
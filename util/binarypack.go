@@ -0,0 +1,447 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// ErrBinaryPackTruncated is returned by Unpack when the data ends in the middle of a value
+var ErrBinaryPackTruncated = errors.New("binarypack: truncated data")
+
+// Pack encodes v with the js-binarypack format used by PeerJS binary
+// serialization. nil, booleans, numbers, strings, []byte, slices and maps with
+// string keys are supported, other values are encoded as their JSON
+// representation.
+func Pack(v interface{}) ([]byte, error) {
+	p := packer{buf: bytes.NewBuffer([]byte{})}
+	err := p.pack(v)
+	if err != nil {
+		return nil, err
+	}
+	return p.buf.Bytes(), nil
+}
+
+type packer struct {
+	buf *bytes.Buffer
+}
+
+func (p *packer) writeUint(size int, value uint64) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, value)
+	p.buf.Write(b[8-size:])
+}
+
+func (p *packer) pack(v interface{}) error {
+	switch value := v.(type) {
+	case nil:
+		p.buf.WriteByte(0xc0)
+	case bool:
+		if value {
+			p.buf.WriteByte(0xc3)
+		} else {
+			p.buf.WriteByte(0xc2)
+		}
+	case string:
+		p.packString(value)
+	case []byte:
+		p.packBin(value)
+	case int:
+		p.packInt(int64(value))
+	case int8:
+		p.packInt(int64(value))
+	case int16:
+		p.packInt(int64(value))
+	case int32:
+		p.packInt(int64(value))
+	case int64:
+		p.packInt(value)
+	case uint:
+		p.packUint(uint64(value))
+	case uint8:
+		p.packUint(uint64(value))
+	case uint16:
+		p.packUint(uint64(value))
+	case uint32:
+		p.packUint(uint64(value))
+	case uint64:
+		p.packUint(value)
+	case float32:
+		p.packFloat(float64(value))
+	case float64:
+		p.packFloat(value)
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			p.packInt(i)
+			return nil
+		}
+		f, err := value.Float64()
+		if err != nil {
+			return err
+		}
+		p.packFloat(f)
+	case []interface{}:
+		p.packArrayHeader(len(value))
+		for _, item := range value {
+			if err := p.pack(item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		p.packMapHeader(len(keys))
+		for _, key := range keys {
+			p.packString(key)
+			if err := p.pack(value[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return p.packReflect(v)
+	}
+	return nil
+}
+
+// packReflect encodes slices, maps and pointers of any type, other values
+// are encoded as their JSON representation
+func (p *packer) packReflect(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return p.pack(nil)
+		}
+		return p.pack(rv.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return p.pack(nil)
+		}
+		p.packArrayHeader(rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			if err := p.pack(rv.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("binarypack: unsupported map key type %s", rv.Type().Key())
+		}
+		keys := make([]string, 0, rv.Len())
+		for _, key := range rv.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+		p.packMapHeader(len(keys))
+		for _, key := range keys {
+			p.packString(key)
+			item := rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key()))
+			if err := p.pack(item.Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("binarypack: unsupported type %T: %s", v, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	err = decoder.Decode(&value)
+	if err != nil {
+		return err
+	}
+	return p.pack(value)
+}
+
+func (p *packer) packString(value string) {
+	size := len(value)
+	switch {
+	case size <= 0x0f:
+		p.buf.WriteByte(0xb0 + byte(size))
+	case size <= 0xffff:
+		p.buf.WriteByte(0xd8)
+		p.writeUint(2, uint64(size))
+	default:
+		p.buf.WriteByte(0xd9)
+		p.writeUint(4, uint64(size))
+	}
+	p.buf.WriteString(value)
+}
+
+func (p *packer) packBin(value []byte) {
+	size := len(value)
+	switch {
+	case size <= 0x0f:
+		p.buf.WriteByte(0xa0 + byte(size))
+	case size <= 0xffff:
+		p.buf.WriteByte(0xda)
+		p.writeUint(2, uint64(size))
+	default:
+		p.buf.WriteByte(0xdb)
+		p.writeUint(4, uint64(size))
+	}
+	p.buf.Write(value)
+}
+
+func (p *packer) packArrayHeader(size int) {
+	switch {
+	case size <= 0x0f:
+		p.buf.WriteByte(0x90 + byte(size))
+	case size <= 0xffff:
+		p.buf.WriteByte(0xdc)
+		p.writeUint(2, uint64(size))
+	default:
+		p.buf.WriteByte(0xdd)
+		p.writeUint(4, uint64(size))
+	}
+}
+
+func (p *packer) packMapHeader(size int) {
+	switch {
+	case size <= 0x0f:
+		p.buf.WriteByte(0x80 + byte(size))
+	case size <= 0xffff:
+		p.buf.WriteByte(0xde)
+		p.writeUint(2, uint64(size))
+	default:
+		p.buf.WriteByte(0xdf)
+		p.writeUint(4, uint64(size))
+	}
+}
+
+func (p *packer) packInt(value int64) {
+	if value >= 0 {
+		p.packUint(uint64(value))
+		return
+	}
+	switch {
+	case value >= -0x20:
+		p.buf.WriteByte(byte(value))
+	case value >= -0x80:
+		p.buf.WriteByte(0xd0)
+		p.writeUint(1, uint64(value))
+	case value >= -0x8000:
+		p.buf.WriteByte(0xd1)
+		p.writeUint(2, uint64(value))
+	case value >= -0x80000000:
+		p.buf.WriteByte(0xd2)
+		p.writeUint(4, uint64(value))
+	default:
+		p.buf.WriteByte(0xd3)
+		p.writeUint(8, uint64(value))
+	}
+}
+
+func (p *packer) packUint(value uint64) {
+	switch {
+	case value <= 0x7f:
+		p.buf.WriteByte(byte(value))
+	case value <= 0xff:
+		p.buf.WriteByte(0xcc)
+		p.writeUint(1, value)
+	case value <= 0xffff:
+		p.buf.WriteByte(0xcd)
+		p.writeUint(2, value)
+	case value <= 0xffffffff:
+		p.buf.WriteByte(0xce)
+		p.writeUint(4, value)
+	default:
+		p.buf.WriteByte(0xcf)
+		p.writeUint(8, value)
+	}
+}
+
+func (p *packer) packFloat(value float64) {
+	if value == math.Trunc(value) && value >= math.MinInt64 && value < math.MaxInt64 {
+		// JS packs integral numbers as integers
+		p.packInt(int64(value))
+		return
+	}
+	p.buf.WriteByte(0xcb)
+	p.writeUint(8, math.Float64bits(value))
+}
+
+// Unpack decodes js-binarypack data. Integers are decoded as int64, or uint64
+// if they overflow it, floating point numbers as float64, binary data as
+// []byte, arrays as []interface{} and maps as map[string]interface{}
+func Unpack(raw []byte) (interface{}, error) {
+	u := unpacker{data: raw}
+	return u.unpack()
+}
+
+type unpacker struct {
+	data  []byte
+	index int
+}
+
+func (u *unpacker) read(size int) ([]byte, error) {
+	if size < 0 || u.index+size > len(u.data) {
+		return nil, ErrBinaryPackTruncated
+	}
+	b := u.data[u.index : u.index+size]
+	u.index += size
+	return b, nil
+}
+
+func (u *unpacker) readUint(size int) (uint64, error) {
+	b, err := u.read(size)
+	if err != nil {
+		return 0, err
+	}
+	var value uint64
+	for _, c := range b {
+		value = value<<8 | uint64(c)
+	}
+	return value, nil
+}
+
+func (u *unpacker) unpack() (interface{}, error) {
+	b, err := u.read(1)
+	if err != nil {
+		return nil, err
+	}
+	t := b[0]
+
+	switch {
+	case t < 0x80:
+		return int64(t), nil
+	case t^0xe0 < 0x20:
+		return int64(int8(t)), nil
+	case t^0xa0 <= 0x0f:
+		return u.unpackBin(int(t ^ 0xa0))
+	case t^0xb0 <= 0x0f:
+		return u.unpackString(int(t ^ 0xb0))
+	case t^0x90 <= 0x0f:
+		return u.unpackArray(int(t ^ 0x90))
+	case t^0x80 <= 0x0f:
+		return u.unpackMap(int(t ^ 0x80))
+	}
+
+	switch t {
+	case 0xc0, 0xc1:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xca:
+		value, err := u.readUint(4)
+		return float64(math.Float32frombits(uint32(value))), err
+	case 0xcb:
+		value, err := u.readUint(8)
+		return math.Float64frombits(value), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		value, err := u.readUint(1 << (t - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if value > math.MaxInt64 {
+			return value, nil
+		}
+		return int64(value), nil
+	case 0xd0:
+		value, err := u.readUint(1)
+		return int64(int8(value)), err
+	case 0xd1:
+		value, err := u.readUint(2)
+		return int64(int16(value)), err
+	case 0xd2:
+		value, err := u.readUint(4)
+		return int64(int32(value)), err
+	case 0xd3:
+		value, err := u.readUint(8)
+		return int64(value), err
+	case 0xd8, 0xd9:
+		size, err := u.readUint(2 << (t - 0xd8))
+		if err != nil {
+			return nil, err
+		}
+		return u.unpackString(int(size))
+	case 0xda, 0xdb:
+		size, err := u.readUint(2 << (t - 0xda))
+		if err != nil {
+			return nil, err
+		}
+		return u.unpackBin(int(size))
+	case 0xdc, 0xdd:
+		size, err := u.readUint(2 << (t - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return u.unpackArray(int(size))
+	case 0xde, 0xdf:
+		size, err := u.readUint(2 << (t - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return u.unpackMap(int(size))
+	}
+	return nil, fmt.Errorf("binarypack: unsupported type 0x%x", t)
+}
+
+func (u *unpacker) unpackBin(size int) (interface{}, error) {
+	b, err := u.read(size)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, b...), nil
+}
+
+func (u *unpacker) unpackString(size int) (interface{}, error) {
+	b, err := u.read(size)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (u *unpacker) unpackArray(size int) (interface{}, error) {
+	if size > len(u.data)-u.index {
+		return nil, ErrBinaryPackTruncated
+	}
+	value := make([]interface{}, size)
+	for i := range value {
+		item, err := u.unpack()
+		if err != nil {
+			return nil, err
+		}
+		value[i] = item
+	}
+	return value, nil
+}
+
+func (u *unpacker) unpackMap(size int) (interface{}, error) {
+	if size > len(u.data)-u.index {
+		return nil, ErrBinaryPackTruncated
+	}
+	value := make(map[string]interface{}, size)
+	for i := 0; i < size; i++ {
+		key, err := u.unpack()
+		if err != nil {
+			return nil, err
+		}
+		item, err := u.unpack()
+		if err != nil {
+			return nil, err
+		}
+		if k, ok := key.(string); ok {
+			value[k] = item
+		} else {
+			value[fmt.Sprint(key)] = item
+		}
+	}
+	return value, nil
+}
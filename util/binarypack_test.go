@@ -0,0 +1,97 @@
+package util
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryPackRoundTrip(t *testing.T) {
+	values := []interface{}{
+		nil,
+		true,
+		false,
+		int64(0),
+		int64(127),
+		int64(-32),
+		int64(-33),
+		int64(255),
+		int64(-129),
+		int64(65535),
+		int64(-40000),
+		int64(math.MaxUint32),
+		int64(math.MinInt32) - 1,
+		int64(math.MaxInt64),
+		uint64(math.MaxUint64),
+		1.5,
+		-0.25,
+		"",
+		"hello",
+		string(bytes.Repeat([]byte("a"), 70000)),
+		[]byte{},
+		[]byte{1, 2, 3},
+		bytes.Repeat([]byte{0xff}, 70000),
+		[]interface{}{int64(1), "two", []byte{3}},
+		map[string]interface{}{"a": int64(1), "b": []interface{}{true, nil}},
+	}
+	for _, value := range values {
+		raw, err := Pack(value)
+		assert.NoError(t, err)
+		unpacked, err := Unpack(raw)
+		assert.NoError(t, err)
+		assert.Equal(t, value, unpacked)
+	}
+}
+
+func TestBinaryPackFormat(t *testing.T) {
+	// encodings produced by js-binarypack
+	vectors := []struct {
+		value interface{}
+		raw   []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{1, []byte{0x01}},
+		{-1, []byte{0xff}},
+		{200, []byte{0xcc, 0xc8}},
+		{-100, []byte{0xd0, 0x9c}},
+		{1000, []byte{0xcd, 0x03, 0xe8}},
+		{2.0, []byte{0x02}},
+		{0.5, []byte{0xcb, 0x3f, 0xe0, 0, 0, 0, 0, 0, 0}},
+		{"hi", []byte{0xb2, 'h', 'i'}},
+		{[]byte{1, 2}, []byte{0xa2, 1, 2}},
+		{[]int{1, 2}, []byte{0x92, 1, 2}},
+		{map[string]int{"a": 1}, []byte{0x81, 0xb1, 'a', 1}},
+	}
+	for _, vector := range vectors {
+		raw, err := Pack(vector.value)
+		assert.NoError(t, err)
+		assert.Equal(t, vector.raw, raw, "%v", vector.value)
+	}
+}
+
+func TestBinaryPackStruct(t *testing.T) {
+	type payload struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	raw, err := Pack(payload{Name: "test", Count: 3})
+	assert.NoError(t, err)
+	value, err := Unpack(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "test", "count": int64(3)}, value)
+}
+
+func TestBinaryUnpackTruncated(t *testing.T) {
+	raw, err := Pack([]interface{}{"hello", []byte{1, 2, 3}})
+	assert.NoError(t, err)
+	for i := 0; i < len(raw); i++ {
+		_, err := Unpack(raw[:i])
+		assert.Error(t, err)
+	}
+	// declared size larger than the data
+	_, err = Unpack([]byte{0xdd, 0xff, 0xff, 0xff, 0xff})
+	assert.Equal(t, ErrBinaryPackTruncated, err)
+}
@@ -1,8 +1,11 @@
 package util
 
 import (
+	"fmt"
 	"math"
 	"math/rand"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,6 +18,16 @@ const (
 	ChunkedMTU = 16300
 )
 
+// NormalizePath return the mount path of the server routes with a leading
+// slash and no trailing one, or an empty string for the root path
+func NormalizePath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
+
 func RandomToken() string {
 	b := make([]byte, 11) // PeerJS random tokens are 11 chars long
 	for i := range b {
@@ -31,10 +44,14 @@ type ChunckedData struct {
 	Data     []byte `json:"data"`
 }
 
+// chunkDataCount identifies the messages sliced by Chunk
+var chunkDataCount int32
+
 // Chunk slices a data payload in a list of ChunckedData
 func Chunk(raw []byte) (chunks []ChunckedData) {
 	s := slicer{
-		chunks: chunks,
+		dataCount: int(atomic.AddInt32(&chunkDataCount, 1)),
+		chunks:    chunks,
 	}
 	return s.chunk(raw)
 }
@@ -71,3 +88,99 @@ func (s *slicer) chunk(raw []byte) []ChunckedData {
 
 	return s.chunks
 }
+
+// ChunkHeaderSize max size of the envelope of a chunk frame
+const ChunkHeaderSize = 58
+
+// chunkFlag key flagging the binarypack envelope of a chunk frame
+const chunkFlag = "__peerChunk"
+
+// ChunkFrame a chunk of a message. A frame is sent as the binarypack map
+// {__peerChunk, n, total, length, data} holding the message id, chunk index,
+// chunks count, message length and chunk data
+type ChunkFrame struct {
+	ID     uint32
+	N      uint32
+	Total  uint32
+	Length uint32
+	Data   []byte
+}
+
+// PackChunkFrame encode a chunk frame
+func PackChunkFrame(frame ChunkFrame) ([]byte, error) {
+	return Pack(map[string]interface{}{
+		chunkFlag: frame.ID,
+		"n":       frame.N,
+		"total":   frame.Total,
+		"length":  frame.Length,
+		"data":    frame.Data,
+	})
+}
+
+// ChunkFrames slices raw in frames of at most size bytes, envelope included
+func ChunkFrames(id uint32, raw []byte, size int) ([][]byte, error) {
+	dataSize := size - ChunkHeaderSize
+	if dataSize <= 0 {
+		return nil, fmt.Errorf("chunk size must be greater than %d", ChunkHeaderSize)
+	}
+	total := (len(raw) + dataSize - 1) / dataSize
+	frames := make([][]byte, 0, total)
+	for n := 0; n < total; n++ {
+		start := n * dataSize
+		end := start + dataSize
+		if end > len(raw) {
+			end = len(raw)
+		}
+		frame, err := PackChunkFrame(ChunkFrame{
+			ID:     id,
+			N:      uint32(n),
+			Total:  uint32(total),
+			Length: uint32(len(raw)),
+			Data:   raw[start:end],
+		})
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// ParseChunkFrame decode a chunk frame, returns false if raw is not a frame
+func ParseChunkFrame(raw []byte) (ChunkFrame, bool) {
+	// a fixmap of the five fields of the envelope
+	if len(raw) == 0 || raw[0] != 0x85 {
+		return ChunkFrame{}, false
+	}
+	data, err := Unpack(raw)
+	if err != nil {
+		return ChunkFrame{}, false
+	}
+	envelope, ok := data.(map[string]interface{})
+	if !ok {
+		return ChunkFrame{}, false
+	}
+	fields := [4]uint32{}
+	for i, key := range []string{chunkFlag, "n", "total", "length"} {
+		value, ok := envelope[key].(int64)
+		if !ok || value < 0 || value > math.MaxUint32 {
+			return ChunkFrame{}, false
+		}
+		fields[i] = uint32(value)
+	}
+	chunk, ok := envelope["data"].([]byte)
+	if !ok {
+		return ChunkFrame{}, false
+	}
+	frame := ChunkFrame{
+		ID:     fields[0],
+		N:      fields[1],
+		Total:  fields[2],
+		Length: fields[3],
+		Data:   chunk,
+	}
+	if frame.Total < 2 || frame.N >= frame.Total || uint32(len(frame.Data)) > frame.Length {
+		return ChunkFrame{}, false
+	}
+	return frame, true
+}
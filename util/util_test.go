@@ -2,6 +2,7 @@ package util
 
 import (
 	"bytes"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -15,3 +16,43 @@ func TestChunk(t *testing.T) {
 	chunks := Chunk(data.Bytes())
 	assert.NotEmpty(t, chunks)
 }
+
+func TestChunkFrames(t *testing.T) {
+	raw := bytes.Repeat([]byte("0123456789"), 10)
+	frames, err := ChunkFrames(7, raw, ChunkHeaderSize+30)
+	assert.NoError(t, err)
+	assert.Len(t, frames, 4)
+
+	data := []byte{}
+	for i, f := range frames {
+		assert.LessOrEqual(t, len(f), ChunkHeaderSize+30)
+		frame, ok := ParseChunkFrame(f)
+		assert.True(t, ok)
+		assert.Equal(t, uint32(7), frame.ID)
+		assert.Equal(t, uint32(i), frame.N)
+		assert.Equal(t, uint32(4), frame.Total)
+		assert.Equal(t, uint32(len(raw)), frame.Length)
+		data = append(data, frame.Data...)
+	}
+	assert.Equal(t, raw, data)
+
+	_, err = ChunkFrames(1, raw, ChunkHeaderSize)
+	assert.Error(t, err)
+
+	_, ok := ParseChunkFrame(raw)
+	assert.False(t, ok)
+
+	// the envelope never exceeds ChunkHeaderSize
+	data = bytes.Repeat([]byte{1}, 0x10000)
+	frame, err := PackChunkFrame(ChunkFrame{ID: math.MaxUint32, N: math.MaxUint32 - 1, Total: math.MaxUint32, Length: math.MaxUint32, Data: data})
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, len(frame), ChunkHeaderSize+len(data))
+}
+
+func TestNormalizePath(t *testing.T) {
+	assert.Equal(t, "", NormalizePath(""))
+	assert.Equal(t, "", NormalizePath("/"))
+	assert.Equal(t, "/signaling", NormalizePath("/signaling"))
+	assert.Equal(t, "/signaling", NormalizePath("signaling/"))
+	assert.Equal(t, "/signaling/v1", NormalizePath("/signaling/v1/"))
+}